@@ -0,0 +1,55 @@
+package gohttpxds
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/transport"
+)
+
+// ConfigDump returns the listeners, route configurations, clusters and
+// endpoints received by the xDS client behind an *http.Client built by this
+// package, as the JSON form of an envoy.admin.v3.ConfigDump with the field
+// names used by the Envoy admin interface. Each resource
+// comes with its version_info, last_updated time, client_status and, when its
+// last update was NACKed, its error_state. It fails if the client was not
+// built by this package.
+func ConfigDump(client *http.Client) ([]byte, error) {
+	wrapper, ok := client.Transport.(*transport.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("http client was not built by gohttpxds")
+	}
+	return marshalConfigDump(wrapper)
+}
+
+// ConfigDumpHandler returns an http.Handler serving the ConfigDump of client,
+// to be mounted on an admin or debug endpoint. It fails if the client was not
+// built by this package.
+func ConfigDumpHandler(client *http.Client) (http.Handler, error) {
+	wrapper, ok := client.Transport.(*transport.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("http client was not built by gohttpxds")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump, err := marshalConfigDump(wrapper)
+		if err != nil {
+			log.Error().Err(err).Msg("fail to marshal config dump")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(dump)
+	}), nil
+}
+
+func marshalConfigDump(wrapper *transport.Wrapper) ([]byte, error) {
+	return protojson.MarshalOptions{
+		Multiline:     true,
+		UseProtoNames: true,
+		Resolver:      resourcev3.LenientResolver,
+	}.Marshal(wrapper.ConfigDump())
+}
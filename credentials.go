@@ -0,0 +1,50 @@
+package gohttpxds
+
+import (
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/google"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// InsecureCredentials connects to the management server in plaintext, for
+// control planes running next to the client or in tests.
+func InsecureCredentials() grpc.DialOption {
+	return grpc.WithTransportCredentials(insecure.NewCredentials())
+}
+
+// GoogleDefaultCredentials authenticates to the management server with the
+// Application Default Credentials, as Traffic Director expects.
+func GoogleDefaultCredentials() grpc.DialOption {
+	return grpc.WithCredentialsBundle(google.NewDefaultCredentials())
+}
+
+// TLSCredentials verifies the management server with the CA certificates of
+// caFile, or the system ones if caFile is empty. The file is read again when
+// it changes.
+func TLSCredentials(caFile string) (grpc.DialOption, error) {
+	return MTLSCredentials(caFile, "", "")
+}
+
+// MTLSCredentials is like TLSCredentials but also presents the certificate
+// of certFile and keyFile to the management server, such as the SPIFFE
+// certificate of the workload Istio expects. The files are read again when
+// they change, so that rotated certificates are used on the next connection
+// without restarting the client.
+func MTLSCredentials(caFile, certFile, keyFile string) (grpc.DialOption, error) {
+	creds, err := xdsclient.NewTLSCredentials(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
+
+// TokenFileCredentials sends the token held in the file at path as a bearer
+// token with every request to the management server, such as the JWT Istio
+// mounts in workloads. The file is read again when it changes. They require
+// TLSCredentials or MTLSCredentials, and are set with WithCallCredentials.
+func TokenFileCredentials(path string) credentials.PerRPCCredentials {
+	return xdsclient.NewTokenFileCredentials(path)
+}
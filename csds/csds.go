@@ -0,0 +1,73 @@
+// Package csds implements the Client Status Discovery Service, through which
+// tools like istioctl and Traffic Director query the xDS resources watched by
+// the http.Clients built by gohttpxds, and whether they were ACKed, NACKed,
+// are still requested or do not exist.
+//
+// The service is registered on a gRPC server owned by the application:
+//
+//	server, err := csds.NewServer(client)
+//	// ...
+//	statusv3.RegisterClientStatusDiscoveryServiceServer(grpcServer, server)
+package csds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	statusv3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/transport"
+)
+
+// Server answers Client Status Discovery Service requests with the state of
+// the resources watched by its clients, one ClientConfig per client. The node
+// matchers of the requests are ignored.
+type Server struct {
+	statusv3.UnimplementedClientStatusDiscoveryServiceServer
+
+	wrappers []*transport.Wrapper
+}
+
+// NewServer returns a Server reporting the state of clients. It fails if one
+// of them was not built by gohttpxds.
+func NewServer(clients ...*http.Client) (*Server, error) {
+	s := &Server{}
+	for _, client := range clients {
+		wrapper, ok := client.Transport.(*transport.Wrapper)
+		if !ok {
+			return nil, fmt.Errorf("http client was not built by gohttpxds")
+		}
+		s.wrappers = append(s.wrappers, wrapper)
+	}
+	return s, nil
+}
+
+// FetchClientStatus returns the current state of the clients.
+func (s *Server) FetchClientStatus(_ context.Context, _ *statusv3.ClientStatusRequest) (*statusv3.ClientStatusResponse, error) {
+	return s.clientStatus(), nil
+}
+
+// StreamClientStatus answers every request of the stream with the current
+// state of the clients.
+func (s *Server) StreamClientStatus(stream statusv3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(s.clientStatus()); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) clientStatus() *statusv3.ClientStatusResponse {
+	resp := &statusv3.ClientStatusResponse{}
+	for _, wrapper := range s.wrappers {
+		resp.Config = append(resp.Config, wrapper.ClientConfig())
+	}
+	return resp
+}
@@ -0,0 +1,22 @@
+package gohttpxds
+
+import (
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
+)
+
+// ConfigEvent describes the change of a single xDS resource, identified by
+// its type URL and name. Old holds the previous version of the resource and
+// is nil when it was added; New holds the current one and is nil when it was
+// removed. Both are shared with the client and must not be modified.
+// OldVersion and NewVersion are their versions as sent by the management
+// server.
+type ConfigEvent = xdscache.Event
+
+// ConfigEventType tells how a resource changed.
+type ConfigEventType = xdscache.EventType
+
+const (
+	ResourceAdded   = xdscache.ResourceAdded
+	ResourceUpdated = xdscache.ResourceUpdated
+	ResourceRemoved = xdscache.ResourceRemoved
+)
@@ -1,6 +1,7 @@
 package gohttpxds
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -9,10 +10,11 @@ import (
 	"github.com/k3rn3l-p4n1c/gohttpxds/transport"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func Register(serverURI string, creds grpc.DialOption, nodeId string) {
-	httpXdsClient, err := NewHttpClient(serverURI, creds, nodeId)
+func Register(serverURI string, creds grpc.DialOption, nodeId string, opts ...Option) {
+	httpXdsClient, err := NewHttpClient(serverURI, creds, nodeId, opts...)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -20,13 +22,136 @@ func Register(serverURI string, creds grpc.DialOption, nodeId string) {
 	http.DefaultClient = httpXdsClient
 }
 
-func NewHttpClient(ServerURI string, Creds grpc.DialOption, nodeId string) (*http.Client, error) {
-	xdsClient, err := xdsclient.New(xdsclient.ServerConfig{ServerURI: ServerURI, Creds: Creds, NodeId: nodeId})
+// RegisterFromBootstrap is like Register but reads the management server,
+// credentials and node identity from the same bootstrap file as proxyless
+// gRPC, found at ${GRPC_XDS_BOOTSTRAP} or held in ${GRPC_XDS_BOOTSTRAP_CONFIG}.
+func RegisterFromBootstrap(opts ...Option) {
+	httpXdsClient, err := NewHttpClientFromBootstrap(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	http.DefaultClient = httpXdsClient
+}
+
+func NewHttpClient(ServerURI string, Creds grpc.DialOption, nodeId string, opts ...Option) (*http.Client, error) {
+	return newHttpClient(xdsclient.ServerConfig{ServerURI: ServerURI, Creds: Creds, NodeId: nodeId}, opts)
+}
+
+// NewHttpClientFromBootstrap is like NewHttpClient but reads its configuration
+// from the proxyless gRPC bootstrap file.
+func NewHttpClientFromBootstrap(opts ...Option) (*http.Client, error) {
+	config, err := xdsclient.NewConfigFromBootstrap()
+	if err != nil {
+		return nil, fmt.Errorf("fail to read xds bootstrap: %w", err)
+	}
+	return newHttpClient(config, opts)
+}
+
+// Close shuts down the xDS client behind an *http.Client built by this
+// package, closing its streams and connection to the management server. It
+// fails if the client was not built by this package.
+func Close(client *http.Client) error {
+	wrapper, ok := client.Transport.(*transport.Wrapper)
+	if !ok {
+		return fmt.Errorf("http client was not built by gohttpxds")
+	}
+	wrapper.Close()
+	return nil
+}
+
+// WaitForReady blocks until the xDS client behind an *http.Client built by
+// this package has received the listeners and every route configuration,
+// cluster and endpoints resource they reference, or ctx is done. It fails if
+// the client was not built by this package.
+func WaitForReady(ctx context.Context, client *http.Client) error {
+	wrapper, ok := client.Transport.(*transport.Wrapper)
+	if !ok {
+		return fmt.Errorf("http client was not built by gohttpxds")
+	}
+	return wrapper.WaitForReady(ctx)
+}
+
+// Subscribe calls fn with an event for every listener, route configuration,
+// cluster and endpoints resource held by the xDS client behind an
+// *http.Client built by this package, then for every change to them, until
+// cancel is called. Events are delivered one at a time on a goroutine of
+// their own, so a slow fn does not hold back the xDS updates. It fails if the
+// client was not built by this package.
+func Subscribe(client *http.Client, fn func(ConfigEvent)) (cancel func(), err error) {
+	wrapper, ok := client.Transport.(*transport.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("http client was not built by gohttpxds")
+	}
+	return wrapper.Subscribe(fn), nil
+}
+
+// NewHttpClientFromFile is like NewHttpClient but routes with the listeners,
+// route configurations, clusters and cluster load assignments of an
+// Envoy-style YAML or JSON file instead of a management server, for local
+// development and CI. Listeners and clusters are read from static_resources
+// as in an Envoy bootstrap, and resources of any type from a resources list
+// as in the files of Envoy's path-based config sources. WithFileReload makes
+// it pick up changes to the file.
+func NewHttpClientFromFile(path string, opts ...Option) (*http.Client, error) {
+	o := newOptions(opts)
+	xdsClient, err := xdsclient.NewFromFile(path, o.fileReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create xds client: %w", err)
+	}
+	return newHttpClientWith(xdsClient, o), nil
+}
+
+func newHttpClient(config xdsclient.ServerConfig, opts []Option) (*http.Client, error) {
+	o := newOptions(opts)
+	config.WatchExpiryTimeout = o.watchExpiryTimeout
+	config.PersistDir = o.persistDir
+	config.MaxStaleness = o.maxStaleness
+	config.Delta = config.Delta || o.delta
+	config.FallbackServers = append(config.FallbackServers, o.fallbackServers...)
+	for name, authority := range o.authorities {
+		if config.Authorities == nil {
+			config.Authorities = make(map[string]*xdsclient.Authority)
+		}
+		config.Authorities[name] = authority
+	}
+	if o.callCreds != nil {
+		setCallCreds(&config, o.callCreds)
+		for _, authority := range config.Authorities {
+			if authority.XDSServer != nil {
+				setCallCreds(authority.XDSServer, o.callCreds)
+			}
+		}
+	}
+
+	xdsClient, err := xdsclient.New(config)
 	if err != nil {
 		return nil, fmt.Errorf("fail to create xds client: %w", err)
 	}
+	return newHttpClientWith(xdsClient, o), nil
+}
+
+// setCallCreds sets creds on the servers of config that have no call
+// credentials of their own.
+func setCallCreds(config *xdsclient.ServerConfig, creds credentials.PerRPCCredentials) {
+	if config.CallCreds == nil {
+		config.CallCreds = creds
+	}
+	for i := range config.FallbackServers {
+		if config.FallbackServers[i].CallCreds == nil {
+			config.FallbackServers[i].CallCreds = creds
+		}
+	}
+}
+
+func newHttpClientWith(xdsClient xdsclient.XDSClient, o options) *http.Client {
 	xdsCache := xdscache.New(xdsClient)
-	xdsCache.WatchCluster("")
+	// Route configurations, clusters and endpoints are watched as the
+	// listeners reference them.
 	xdsCache.WatchListener("")
-	return &http.Client{Transport: transport.New(http.DefaultTransport, xdsCache)}, nil
+	var transportOpts []transport.Option
+	if o.waitForReady {
+		transportOpts = append(transportOpts, transport.WithWaitForReady())
+	}
+	return &http.Client{Transport: transport.New(http.DefaultTransport, xdsCache, transportOpts...)}
 }
@@ -1,18 +1,58 @@
 package xdscache
 
 import (
+	"context"
+
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
-	// endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	statusv3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 )
 
 type XDSCache interface {
-	GetListener(string) ([]*listenerv3.Listener, error)
-	GetRouteConfig(string) ([]*routev3.RouteConfiguration, error)
-	GetCluster(string) ([]*clusterv3.Cluster, error)
+	// Snapshot returns the current generation of the cache. Lookups that
+	// must agree with each other, like those made while routing a request,
+	// should be made on a single Snapshot.
+	Snapshot() *Snapshot
+
+	GetListener(string) (*listenerv3.Listener, error)
+	GetRouteConfig(string) (*routev3.RouteConfiguration, error)
+	GetRouteConfigs() []*routev3.RouteConfiguration
+	GetCluster(string) (*clusterv3.Cluster, error)
+	GetClusterLoadAssignment(string) (*endpointv3.ClusterLoadAssignment, error)
 
 	WatchListener(string)
 	WatchRouteConfig(string)
 	WatchCluster(string)
+	WatchEndpoints(string)
+
+	// WatchVirtualHost fetches on demand the virtual host of host in the
+	// named route configuration if it enables VHDS, and blocks until the
+	// management server answered or ctx is done. It returns the error the
+	// watch failed with, if any.
+	WatchVirtualHost(ctx context.Context, routeConfigName, host string) error
+
+	// WaitForReady blocks until the watched resources and the route
+	// configurations, clusters and endpoints they reference have all been
+	// received, or ctx is done. Resources known not to exist count as
+	// received.
+	WaitForReady(ctx context.Context) error
+
+	// Subscribe calls fn with an event for every resource already held and
+	// every change published afterwards, on a goroutine serializing the
+	// deliveries, until cancel is called.
+	Subscribe(fn func(Event)) (cancel func())
+
+	// ConfigDump returns the state of the watched resources in the shape of
+	// the config_dump of the Envoy admin interface.
+	ConfigDump() *adminv3.ConfigDump
+
+	// ClientConfig returns the state of every watched resource in the shape
+	// reported by the Client Status Discovery Service.
+	ClientConfig() *statusv3.ClientConfig
+
+	// Close stops every watch and closes the underlying xDS client.
+	Close()
 }
@@ -1,119 +1,504 @@
 package xdscache
 
 import (
-	"fmt"
+	"context"
+	"sync"
+	"sync/atomic"
 
 	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient"
-	"google.golang.org/protobuf/proto"
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
 
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
-	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 
 	"github.com/rs/zerolog/log"
 )
 
 func New(xdsClient xdsclient.XDSClient) XDSCache {
-	return &xdsCache{
-		xdsClient:              xdsClient,
-		listeners:              make(map[string][]*listenerv3.Listener),
-		routeConfigs:           make(map[string][]*routev3.RouteConfiguration),
-		clusters:               make(map[string][]*clusterv3.Cluster),
-		virtualHosts:           make(map[string][]*routev3.VirtualHost),
-		clusterLoadAssignments: make(map[string][]*endpointv3.ClusterLoadAssignment),
+	x := &xdsCache{
+		xdsClient:     xdsClient,
+		dependencies:  make(map[resourcev3.Type]map[string]*dependency),
+		references:    make(map[resourcev3.Type]map[string][]string),
+		onDemand:      make(map[string][]string),
+		srdsListeners: make(map[string]struct{}),
+		inline:        make(map[string][]string),
+		roots:         make(map[*watcher]bool),
+		ready:         event.NewEvent(),
+
+		subscriptions: make(map[*subscription]struct{}),
+		dispatcher:    newDispatcher(),
 	}
+	x.snapshot.Store(newSnapshot())
+	return x
 }
 
 type xdsCache struct {
 	xdsClient xdsclient.XDSClient
 
-	listeners              map[string][]*listenerv3.Listener
-	routeConfigs           map[string][]*routev3.RouteConfiguration
-	clusters               map[string][]*clusterv3.Cluster
-	virtualHosts           map[string][]*routev3.VirtualHost
-	clusterLoadAssignments map[string][]*endpointv3.ClusterLoadAssignment
+	// snapshot is the current generation, read without locking.
+	snapshot atomic.Pointer[Snapshot]
+
+	// mu serializes the writers. pending is the next generation, built from
+	// the updates of a response and published once it has been delivered
+	// entirely. It is nil when there is nothing to publish.
+	mu      sync.Mutex
+	pending *Snapshot
+	// dependencies holds the watches started because other resources
+	// reference them, keyed by type and name.
+	dependencies map[resourcev3.Type]map[string]*dependency
+	// references holds the names each resource references, keyed by the
+	// type and name of the referencing resource.
+	references map[resourcev3.Type]map[string][]string
+	// onDemand holds the virtual hosts fetched through VHDS, keyed by the
+	// name of their route configuration.
+	onDemand map[string][]string
+	// srdsListeners holds the listeners fetching scopes through SRDS.
+	srdsListeners map[string]struct{}
+	// inline holds the names the route configurations held inline by each
+	// listener are stored under, keyed by listener name.
+	inline map[string][]string
+	// roots holds the watches started through the Watch methods, and whether
+	// a response has been delivered to them yet.
+	roots map[*watcher]bool
+
+	// ready fires once every root and every dependency has been resolved.
+	ready *event.Event
+
+	// subscriptions are notified of the changes of each published generation
+	// through dispatcher.
+	subscriptions map[*subscription]struct{}
+	dispatcher    *dispatcher
 }
 
-func (x *xdsCache) GetListener(name string) ([]*listenerv3.Listener, error) {
-	resource, exists := x.listeners[name]
-	if !exists {
-		return nil, fmt.Errorf("resource not found")
-	}
+// subscription is registered by Subscribe.
+type subscription struct {
+	fn        func(Event)
+	cancelled atomic.Bool
+}
+
+// Snapshot returns the current generation of the cache.
+func (x *xdsCache) Snapshot() *Snapshot {
+	return x.snapshot.Load()
+}
+
+func (x *xdsCache) GetListener(name string) (*listenerv3.Listener, error) {
+	return x.Snapshot().GetListener(name)
+}
+func (x *xdsCache) GetRouteConfig(name string) (*routev3.RouteConfiguration, error) {
+	return x.Snapshot().GetRouteConfig(name)
+}
+func (x *xdsCache) GetRouteConfigs() []*routev3.RouteConfiguration {
+	return x.Snapshot().GetRouteConfigs()
+}
+func (x *xdsCache) GetCluster(name string) (*clusterv3.Cluster, error) {
+	return x.Snapshot().GetCluster(name)
+}
+func (x *xdsCache) GetClusterLoadAssignment(name string) (*endpointv3.ClusterLoadAssignment, error) {
+	return x.Snapshot().GetClusterLoadAssignment(name)
+}
+
+// modify applies fn to the pending generation, creating it from the current
+// one if needed. fn must not call back into the cache.
+func (x *xdsCache) modify(fn func(*Snapshot)) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
 
-	return resource, nil
+	if x.pending == nil {
+		x.pending = x.Snapshot().clone()
+	}
+	fn(x.pending)
 }
-func (x *xdsCache) GetRouteConfig(name string) ([]*routev3.RouteConfiguration, error) {
-	if name == "" {
-		resources := []*routev3.RouteConfiguration{}
-		for k := range x.routeConfigs {
-			resources = append(resources, x.routeConfigs[k]...)
+
+// publish makes the pending generation, if any, the current one.
+func (x *xdsCache) publish() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.pending != nil {
+		current := x.Snapshot()
+		if events := current.diff(x.pending); len(events) > 0 {
+			current.setVersions(x.pending, events, x.xdsClient.DumpResources(), x.inlineListenersLocked())
+			if len(x.subscriptions) > 0 {
+				x.dispatchLocked(events, x.subscriptions)
+			}
+		}
+		x.snapshot.Store(x.pending)
+		x.pending = nil
+	}
+	for name, dep := range x.dependencies[resourcev3.VirtualHostType] {
+		if (!dep.answered.HasFired() || dep.err != nil) && x.resolvedTreeLocked(resourcev3.VirtualHostType, name) {
+			dep.err = nil
+			dep.answered.Fire()
 		}
-		return resources, nil
 	}
-	resource, exists := x.routeConfigs[name]
-	if !exists {
-		return nil, fmt.Errorf("resource not found")
+	if !x.ready.HasFired() && x.readyLocked() {
+		log.Info().Msg("xds config is ready")
+		x.ready.Fire()
 	}
+}
 
-	return resource, nil
+// inlineListenersLocked maps the name of every inline route configuration to
+// the listener holding it. It must be called with x.mu held.
+func (x *xdsCache) inlineListenersLocked() map[string]string {
+	listeners := make(map[string]string)
+	for listener, names := range x.inline {
+		for _, name := range names {
+			listeners[name] = listener
+		}
+	}
+	return listeners
+}
 
+// readyLocked reports whether the watched resources and everything they
+// reference have been resolved.
+func (x *xdsCache) readyLocked() bool {
+	if len(x.roots) == 0 {
+		return false
+	}
+	for _, resolved := range x.roots {
+		if !resolved {
+			return false
+		}
+	}
+	for _, deps := range x.dependencies {
+		for _, dep := range deps {
+			if !dep.resolved {
+				return false
+			}
+		}
+	}
+	return true
 }
-func (x *xdsCache) GetCluster(name string) ([]*clusterv3.Cluster, error) {
-	resource, exists := x.clusters[name]
-	if !exists {
-		return nil, fmt.Errorf("resource not found")
+
+// Subscribe calls fn with an event for every resource added, updated or
+// removed from now on, starting with one ResourceAdded event per resource
+// already held. Events are delivered one at a time, in order, on a goroutine
+// shared by every subscription; fn should not block for long since it delays
+// the other subscriptions, but it never delays the xDS streams. cancel stops
+// the deliveries, except the one running.
+func (x *xdsCache) Subscribe(fn func(Event)) (cancel func()) {
+	sub := &subscription{fn: fn}
+
+	x.mu.Lock()
+	x.subscriptions[sub] = struct{}{}
+	x.dispatchLocked(x.Snapshot().added(), map[*subscription]struct{}{sub: {}})
+	x.mu.Unlock()
+
+	return func() {
+		sub.cancelled.Store(true)
+		x.mu.Lock()
+		delete(x.subscriptions, sub)
+		x.mu.Unlock()
 	}
+}
 
-	return resource, nil
+// dispatchLocked schedules the delivery of events to subs. It must be called
+// with x.mu held, so that the events are delivered in the order the
+// generations were published.
+func (x *xdsCache) dispatchLocked(events []Event, subs map[*subscription]struct{}) {
+	if len(events) == 0 {
+		return
+	}
+	recipients := make([]*subscription, 0, len(subs))
+	for sub := range subs {
+		recipients = append(recipients, sub)
+	}
+	x.dispatcher.schedule(func() {
+		for _, sub := range recipients {
+			for _, e := range events {
+				if sub.cancelled.Load() {
+					break
+				}
+				sub.fn(e)
+			}
+		}
+	})
+}
 
+// WaitForReady blocks until the watched resources and everything they
+// reference have been received, or ctx is done.
+func (x *xdsCache) WaitForReady(ctx context.Context) error {
+	select {
+	case <-x.ready.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+// WatchListener watches the named listener, or every listener when name is
+// empty. The route configurations, clusters and endpoints it leads to are
+// watched as well, for as long as they are referenced.
 func (x *xdsCache) WatchListener(name string) {
-	x.xdsClient.WatchListener(name, x.listenerCallback)
+	x.watchRoot(resourcev3.ListenerType, name)
 }
 func (x *xdsCache) WatchRouteConfig(name string) {
-	x.xdsClient.WatchRouteConfig(name, x.routeConfigCallback)
+	x.watchRoot(resourcev3.RouteConfigType, name)
 }
 func (x *xdsCache) WatchCluster(name string) {
-	x.xdsClient.WatchCluster(name, x.clusterCallback)
+	x.watchRoot(resourcev3.ClusterType, name)
 }
+func (x *xdsCache) WatchEndpoints(name string) {
+	x.watchRoot(resourcev3.EndpointsType, name)
+}
+
+// WatchVirtualHost fetches on demand, through VHDS, the virtual host of host
+// in the named route configuration, and blocks until the management server
+// answered or ctx is done. It returns right away if the route configuration
+// does not enable VHDS, or if the virtual host was already fetched. The
+// virtual host is kept for as long as the route configuration enables VHDS.
+// It returns the error the watch failed with before being answered, such as
+// VHDS being used over the state-of-the-world protocol.
+func (x *xdsCache) WatchVirtualHost(ctx context.Context, routeConfigName, host string) error {
+	name := resourcev3.VirtualHostName(routeConfigName, host)
+	var ops dependencyOps
 
-func (x *xdsCache) listenerCallback(resources []*listenerv3.Listener, err error) {
-	log.Debug().Int("count", len(resources)).Msg("new listeners received")
-	for _, resource := range resources {
-		x.listeners[resource.Name] = append(x.listeners[resource.Name], resource)
+	x.mu.Lock()
+	current := x.pending
+	if current == nil {
+		current = x.Snapshot()
+	}
+	if current.routeConfigs[routeConfigName].GetVhds() == nil {
+		x.mu.Unlock()
+		return nil
 	}
+	dep, ok := x.dependencies[resourcev3.VirtualHostType][name]
+	if !ok {
+		x.acquireLocked(resourcev3.VirtualHostType, name, &ops)
+		dep = x.dependencies[resourcev3.VirtualHostType][name]
+		dep.answered = event.NewEvent()
+		x.onDemand[routeConfigName] = append(x.onDemand[routeConfigName], name)
+	}
+	answered := dep.answered
+	x.mu.Unlock()
+	x.apply(&ops)
 
-	for l := range resources {
-		for i := range resources[l].FilterChains {
-			for j := range resources[l].FilterChains[i].Filters {
-				manager := &hcmv3.HttpConnectionManager{}
-				if err := proto.Unmarshal(resources[l].FilterChains[i].Filters[j].GetTypedConfig().GetValue(), manager); err != nil {
-					panic(fmt.Errorf("failed to unmarshal resource: %w", err).Error())
-				}
+	select {
+	case <-answered.Done():
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		return dep.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-				if hcmrds, ok := manager.GetRouteSpecifier().(*hcmv3.HttpConnectionManager_Rds); ok {
-					x.WatchRouteConfig(hcmrds.Rds.RouteConfigName)
-				}
-			}
-		}
+// failVirtualHost answers the watchers of the named virtual host with err,
+// if it has not been answered yet.
+func (x *xdsCache) failVirtualHost(name string, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	dep, ok := x.dependencies[resourcev3.VirtualHostType][name]
+	if !ok || dep.answered.HasFired() {
+		return
 	}
+	dep.err = err
+	dep.answered.Fire()
 }
-func (x *xdsCache) routeConfigCallback(resources []*routev3.RouteConfiguration, err error) {
-	log.Debug().Int("count", len(resources)).Msg("new routes received")
 
-	for _, resource := range resources {
-		x.routeConfigs[resource.Name] = append(x.routeConfigs[resource.Name], resource)
+// watchRoot starts a watch the cache is not ready without. It is resolved
+// by the first response delivered to it.
+func (x *xdsCache) watchRoot(rType resourcev3.Type, name string) {
+	w := x.newWatcher(rType, name)
+	w.onDone = func() {
+		x.mu.Lock()
+		x.roots[w] = true
+		x.mu.Unlock()
+		x.publish()
 	}
 
+	x.mu.Lock()
+	x.roots[w] = false
+	x.mu.Unlock()
+
+	x.xdsClient.WatchResource(rType, name, w)
 }
-func (x *xdsCache) clusterCallback(resources []*clusterv3.Cluster, err error) {
-	log.Debug().Int("count", len(resources)).Msg("new clusters received")
 
-	for _, resource := range resources {
-		x.clusters[resource.Name] = append(x.clusters[resource.Name], resource)
+// newWatcher returns the watcher storing the resources of type rType in the
+// cache.
+func (x *xdsCache) newWatcher(rType resourcev3.Type, name string) *watcher {
+	w := &watcher{
+		name:      name,
+		onRemoved: func(name string) { x.remove(rType, name) },
+		onDone:    x.publish,
+	}
+	switch rType {
+	case resourcev3.ListenerType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.listenerCallback(data.(*resourcev3.ListenerResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Msg("fail to watch listeners, serving the last received ones")
+		}
+	case resourcev3.RouteConfigType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.routeConfigCallback(data.(*resourcev3.RouteConfigResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Msg("fail to watch routes, serving the last received ones")
+		}
+	case resourcev3.ClusterType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.clusterCallback(data.(*resourcev3.ClusterResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Msg("fail to watch clusters, serving the last received ones")
+		}
+	case resourcev3.EndpointsType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.endpointsCallback(data.(*resourcev3.EndpointsResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Msg("fail to watch endpoints, serving the last received ones")
+		}
+	case resourcev3.VirtualHostType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.virtualHostCallback(name, data.(*resourcev3.VirtualHostResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Str("name", name).Msg("fail to watch virtual host, serving the last received one")
+			x.failVirtualHost(name, err)
+		}
+	case resourcev3.ScopedRouteConfigType:
+		w.onUpdate = func(data resourcev3.ResourceData) {
+			x.scopedRouteConfigCallback(data.(*resourcev3.ScopedRouteConfigResourceData).Resource)
+		}
+		w.onError = func(err error) {
+			log.Warn().Err(err).Msg("fail to watch scoped routes, serving the last received ones")
+		}
+		// Every scope is watched at once: the watch is resolved by the first
+		// response, whatever it holds.
+		w.onDone = func() {
+			x.modify(func(*Snapshot) {
+				x.resolveLocked(resourcev3.ScopedRouteConfigType, name)
+			})
+			x.publish()
+		}
 	}
+	return w
+}
+
+func (x *xdsCache) Close() {
+	x.xdsClient.Close()
+	x.dispatcher.close()
+}
 
+func (x *xdsCache) listenerCallback(resource *listenerv3.Listener) {
+	log.Debug().Str("name", resource.Name).Msg("new listener received")
+
+	managers := httpConnManagers(resource)
+	scoped, srds := scopedRoutes(managers)
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		s.listeners[resource.Name] = resource
+		if len(scoped) > 0 {
+			s.scopedRoutes[resource.Name] = scoped
+		} else {
+			delete(s.scopedRoutes, resource.Name)
+		}
+		x.setReferencesLocked(s, resourcev3.ListenerType, resource.Name, routeConfigNames(managers), &ops)
+		x.setScopedRDSLocked(s, resource.Name, srds, &ops)
+		x.setInlineRouteConfigsLocked(s, resource.Name, inlineRouteConfigs(resource.Name, managers), &ops)
+		x.resolveLocked(resourcev3.ListenerType, resource.Name)
+	})
+	x.apply(&ops)
+}
+func (x *xdsCache) scopedRouteConfigCallback(resource *routev3.ScopedRouteConfiguration) {
+	log.Debug().Str("name", resource.Name).Msg("new scoped route received")
+
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		if _, ok := x.dependencies[resourcev3.ScopedRouteConfigType][""]; !ok {
+			// Released while the update was delivered.
+			return
+		}
+		s.scopedRouteConfigs[resource.Name] = resource
+		x.setReferencesLocked(s, resourcev3.ScopedRouteConfigType, resource.Name, []string{resource.RouteConfigurationName}, &ops)
+	})
+	x.apply(&ops)
+}
+func (x *xdsCache) routeConfigCallback(resource *routev3.RouteConfiguration) {
+	log.Debug().Str("name", resource.Name).Msg("new route received")
+
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		s.routeConfigs[resource.Name] = resource
+		x.setReferencesLocked(s, resourcev3.RouteConfigType, resource.Name, clusterNames(resource.GetVirtualHosts()...), &ops)
+		if resource.GetVhds() == nil {
+			x.releaseVirtualHostsLocked(s, resource.Name, &ops)
+		}
+		x.resolveLocked(resourcev3.RouteConfigType, resource.Name)
+	})
+	x.apply(&ops)
+}
+
+// virtualHostCallback stores the virtual host watched as name, which is not
+// necessarily the name it holds.
+func (x *xdsCache) virtualHostCallback(name string, resource *routev3.VirtualHost) {
+	log.Debug().Str("name", name).Msg("new virtual host received")
+
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		if _, ok := x.dependencies[resourcev3.VirtualHostType][name]; !ok {
+			// Released while the update was delivered.
+			return
+		}
+		s.virtualHosts[name] = resource
+		x.setReferencesLocked(s, resourcev3.VirtualHostType, name, clusterNames(resource), &ops)
+		x.resolveLocked(resourcev3.VirtualHostType, name)
+	})
+	x.apply(&ops)
+}
+func (x *xdsCache) clusterCallback(resource *clusterv3.Cluster) {
+	log.Debug().Str("name", resource.Name).Msg("new cluster received")
+
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		s.clusters[resource.Name] = resource
+		x.setReferencesLocked(s, resourcev3.ClusterType, resource.Name, endpointsNames(resource), &ops)
+		x.resolveLocked(resourcev3.ClusterType, resource.Name)
+	})
+	x.apply(&ops)
+}
+func (x *xdsCache) endpointsCallback(resource *endpointv3.ClusterLoadAssignment) {
+	log.Debug().Str("name", resource.ClusterName).Msg("new endpoints received")
+
+	x.modify(func(s *Snapshot) {
+		s.clusterLoadAssignments[resource.ClusterName] = resource
+		x.resolveLocked(resourcev3.EndpointsType, resource.ClusterName)
+	})
+}
+
+// remove evicts a resource the management server deleted or never sent, and
+// releases what it referenced.
+func (x *xdsCache) remove(rType resourcev3.Type, name string) {
+	log.Debug().Str("type", rType.TypeURL()).Str("name", name).Msg("resource removed")
+
+	var ops dependencyOps
+	x.modify(func(s *Snapshot) {
+		s.remove(rType, name)
+		x.setReferencesLocked(s, rType, name, nil, &ops)
+		switch rType {
+		case resourcev3.ListenerType:
+			x.setScopedRDSLocked(s, name, false, &ops)
+			x.setInlineRouteConfigsLocked(s, name, nil, &ops)
+		case resourcev3.RouteConfigType:
+			x.releaseVirtualHostsLocked(s, name, &ops)
+		}
+		x.resolveLocked(rType, name)
+	})
+	x.apply(&ops)
+}
+
+// EDSServiceName returns the name of the ClusterLoadAssignment holding the
+// endpoints of an EDS cluster, which defaults to the cluster name.
+func EDSServiceName(cluster *clusterv3.Cluster) string {
+	if name := cluster.GetEdsClusterConfig().GetServiceName(); name != "" {
+		return name
+	}
+	return cluster.GetName()
 }
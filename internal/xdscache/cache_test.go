@@ -0,0 +1,664 @@
+package xdscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	xdscorev3 "github.com/cncf/xds/go/xds/core/v3"
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// fakeClient records the watches made by the cache so tests can deliver
+// resources to them.
+type fakeClient struct {
+	mu       sync.Mutex
+	watchers map[string]map[string]resourcev3.ResourceWatcher
+	// resources is returned by DumpResources.
+	resources map[string]map[string]resourcev3.UpdateWithMD
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{watchers: make(map[string]map[string]resourcev3.ResourceWatcher)}
+}
+
+func (f *fakeClient) WatchResource(rType resourcev3.Type, name string, watcher resourcev3.ResourceWatcher) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.watchers[rType.TypeURL()] == nil {
+		f.watchers[rType.TypeURL()] = make(map[string]resourcev3.ResourceWatcher)
+	}
+	f.watchers[rType.TypeURL()][name] = watcher
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.watchers[rType.TypeURL()], name)
+	}
+}
+
+func (f *fakeClient) watcher(rType resourcev3.Type, name string) resourcev3.ResourceWatcher {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.watchers[rType.TypeURL()][name]
+}
+
+func (f *fakeClient) WatchListener(string, func([]*listenerv3.Listener, error)) func() {
+	return func() {}
+}
+func (f *fakeClient) WatchRouteConfig(string, func([]*routev3.RouteConfiguration, error)) func() {
+	return func() {}
+}
+func (f *fakeClient) WatchCluster(string, func([]*clusterv3.Cluster, error)) func() {
+	return func() {}
+}
+func (f *fakeClient) WatchEndpoints(string, func([]*endpointv3.ClusterLoadAssignment, error)) func() {
+	return func() {}
+}
+func (f *fakeClient) DumpResources() map[string]map[string]resourcev3.UpdateWithMD {
+	return f.resources
+}
+func (f *fakeClient) Node() *corev3.Node {
+	return &corev3.Node{Id: "node_0"}
+}
+func (f *fakeClient) Close() {}
+
+// deliver hands resources to the watcher as a single response.
+func deliver(w resourcev3.ResourceWatcher, resources ...resourcev3.ResourceData) {
+	for _, r := range resources {
+		w.OnUpdate(r)
+	}
+	w.(resourcev3.BatchWatcher).OnBatchDone()
+}
+
+func TestSnapshot_Update_ShouldNotChangePreviousSnapshot(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchCluster("")
+	w := client.watcher(resourcev3.ClusterType, "")
+
+	deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	before := cache.Snapshot()
+	deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_1"}})
+
+	_, err := before.GetCluster("cluster_1")
+	assert.Error(t, err)
+	_, err = cache.Snapshot().GetCluster("cluster_1")
+	assert.NoError(t, err)
+}
+
+func TestSnapshot_ResponseBeingDelivered_ShouldNotBePublished(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchCluster("")
+	w := client.watcher(resourcev3.ClusterType, "")
+
+	w.OnUpdate(&resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	_, err := cache.GetCluster("cluster_0")
+	assert.Error(t, err)
+
+	w.(resourcev3.BatchWatcher).OnBatchDone()
+	_, err = cache.GetCluster("cluster_0")
+	assert.NoError(t, err)
+}
+
+func TestSnapshot_ConcurrentReadsAndUpdates_ShouldNotRace(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchCluster("")
+	w := client.watcher(resourcev3.ClusterType, "")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, _ = cache.GetCluster("cluster_0")
+	}
+	wg.Wait()
+}
+
+func TestCache_ClusterUpdated_ShouldReplacePreviousVersion(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchCluster("")
+	w := client.watcher(resourcev3.ClusterType, "")
+
+	deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0", AltStatName: "v1"}})
+	deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0", AltStatName: "v2"}})
+
+	cluster, err := cache.GetCluster("cluster_0")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", cluster.GetAltStatName())
+}
+
+func TestCache_ClusterRemoved_ShouldEvict(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchCluster("")
+	w := client.watcher(resourcev3.ClusterType, "")
+
+	deliver(w, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	w.(resourcev3.ResourceRemovedWatcher).OnResourceRemoved("cluster_0")
+	w.(resourcev3.BatchWatcher).OnBatchDone()
+
+	_, err := cache.GetCluster("cluster_0")
+	assert.Error(t, err)
+}
+
+func rdsListener(t *testing.T, name, routeConfigName string) *resourcev3.ListenerResourceData {
+	manager, err := anypb.New(&hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{Rds: &hcmv3.Rds{RouteConfigName: routeConfigName}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resourcev3.ListenerResourceData{Resource: &listenerv3.Listener{
+		Name:        name,
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	}}
+}
+
+func routeConfig(name string, clusters ...string) *resourcev3.RouteConfigResourceData {
+	var routes []*routev3.Route
+	for _, cluster := range clusters {
+		routes = append(routes, &routev3.Route{
+			Action: &routev3.Route_Route{Route: &routev3.RouteAction{
+				ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: cluster},
+			}},
+		})
+	}
+	return &resourcev3.RouteConfigResourceData{Resource: &routev3.RouteConfiguration{
+		Name: name,
+		VirtualHosts: []*routev3.VirtualHost{{
+			Name:    "virtual_host_0",
+			Domains: []string{"*"},
+			Routes:  routes,
+		}},
+	}}
+}
+
+func TestCache_Listener_ShouldWatchReferencedResourcesOnly(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+
+	deliver(client.watcher(resourcev3.ListenerType, ""), rdsListener(t, "listener_0", "route_config_0"))
+	assert.NotNil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), routeConfig("route_config_0", "cluster_0", "cluster_1"))
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_0"))
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_1"))
+	assert.Len(t, client.watchers[resourcev3.ClusterType.TypeURL()], 2)
+
+	deliver(client.watcher(resourcev3.ClusterType, "cluster_1"), &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{
+		Name:                 "cluster_1",
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig:     &clusterv3.Cluster_EdsClusterConfig{ServiceName: "service_1"},
+	}})
+	assert.NotNil(t, client.watcher(resourcev3.EndpointsType, "service_1"))
+}
+
+func TestCache_WeightedClusters_ShouldBeWatched(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchRouteConfig("route_config_0")
+
+	rc := routeConfig("route_config_0", "cluster_0")
+	rc.Resource.VirtualHosts[0].Routes = append(rc.Resource.VirtualHosts[0].Routes, &routev3.Route{
+		Action: &routev3.Route_Route{Route: &routev3.RouteAction{
+			ClusterSpecifier: &routev3.RouteAction_WeightedClusters{WeightedClusters: &routev3.WeightedCluster{
+				Clusters: []*routev3.WeightedCluster_ClusterWeight{{Name: "cluster_1"}, {Name: "cluster_2"}},
+			}},
+		}},
+	})
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), rc)
+
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_0"))
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_1"))
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_2"))
+}
+
+func TestCache_ReferenceDropped_ShouldCancelAndEvict(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	listeners := client.watcher(resourcev3.ListenerType, "")
+
+	deliver(listeners, rdsListener(t, "listener_0", "route_config_0"))
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), routeConfig("route_config_0", "cluster_0"))
+	deliver(client.watcher(resourcev3.ClusterType, "cluster_0"), &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+
+	deliver(listeners, rdsListener(t, "listener_0", "route_config_1"))
+
+	assert.Nil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+	assert.NotNil(t, client.watcher(resourcev3.RouteConfigType, "route_config_1"))
+	assert.Nil(t, client.watcher(resourcev3.ClusterType, "cluster_0"))
+	_, err := cache.GetRouteConfig("route_config_0")
+	assert.Error(t, err)
+	_, err = cache.GetCluster("cluster_0")
+	assert.Error(t, err)
+}
+
+func TestCache_SharedReference_ShouldKeepWatchUntilLastRelease(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	listeners := client.watcher(resourcev3.ListenerType, "")
+
+	deliver(listeners, rdsListener(t, "listener_0", "route_config_0"), rdsListener(t, "listener_1", "route_config_0"))
+	listeners.(resourcev3.ResourceRemovedWatcher).OnResourceRemoved("listener_0")
+	listeners.(resourcev3.BatchWatcher).OnBatchDone()
+	assert.NotNil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+
+	listeners.(resourcev3.ResourceRemovedWatcher).OnResourceRemoved("listener_1")
+	listeners.(resourcev3.BatchWatcher).OnBatchDone()
+	assert.Nil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+}
+
+func TestCache_WaitForReady_ShouldWaitForEveryReferencedResource(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	ready := make(chan error, 1)
+	go func() { ready <- cache.WaitForReady(context.Background()) }()
+
+	deliver(client.watcher(resourcev3.ListenerType, ""), rdsListener(t, "listener_0", "route_config_0"))
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), routeConfig("route_config_0", "cluster_0", "cluster_1"))
+	deliver(client.watcher(resourcev3.ClusterType, "cluster_0"), &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	assert.Never(t, func() bool { return len(ready) > 0 }, 50*time.Millisecond, 10*time.Millisecond, "cluster_1 has not been received yet")
+
+	client.watcher(resourcev3.ClusterType, "cluster_1").OnResourceDoesNotExist()
+	client.watcher(resourcev3.ClusterType, "cluster_1").(resourcev3.BatchWatcher).OnBatchDone()
+	select {
+	case err := <-ready:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("cache should be ready once missing resources are known not to exist")
+	}
+}
+
+func TestCache_WaitForReady_NoResponse_ShouldReturnContextError(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, cache.WaitForReady(ctx), context.DeadlineExceeded)
+}
+
+func TestCache_WatchVirtualHost_ShouldWaitForVirtualHostAndItsClusters(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchRouteConfig("route_config_0")
+	routes := client.watcher(resourcev3.RouteConfigType, "route_config_0")
+	deliver(routes, &resourcev3.RouteConfigResourceData{Resource: &routev3.RouteConfiguration{
+		Name: "route_config_0",
+		Vhds: &routev3.Vhds{},
+	}})
+	answered := make(chan error, 1)
+	go func() { answered <- cache.WatchVirtualHost(context.Background(), "route_config_0", "host_0") }()
+
+	assert.Eventually(t, func() bool {
+		return client.watcher(resourcev3.VirtualHostType, "route_config_0/host_0") != nil
+	}, time.Second, time.Millisecond)
+	// The virtual host is keyed by the name it was watched under, whatever
+	// the name it holds.
+	virtualHost := routeConfig("route_config_0", "cluster_0").Resource.VirtualHosts[0]
+	deliver(client.watcher(resourcev3.VirtualHostType, "route_config_0/host_0"), &resourcev3.VirtualHostResourceData{Resource: virtualHost})
+	assert.Never(t, func() bool { return len(answered) > 0 }, 50*time.Millisecond, 10*time.Millisecond, "cluster_0 has not been received yet")
+
+	deliver(client.watcher(resourcev3.ClusterType, "cluster_0"), &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	select {
+	case err := <-answered:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("virtual host should be answered once its clusters are received")
+	}
+	rc, err := cache.GetRouteConfig("route_config_0")
+	assert.NoError(t, err)
+	assert.Len(t, cache.Snapshot().GetVirtualHosts(rc), 1)
+
+	deliver(routes, routeConfig("route_config_0", "cluster_1"))
+	assert.Nil(t, client.watcher(resourcev3.VirtualHostType, "route_config_0/host_0"), "virtual host should be released once VHDS is disabled")
+	assert.NoError(t, cache.WatchVirtualHost(context.Background(), "route_config_0", "host_0"))
+}
+
+func TestCache_WatchVirtualHostError_ShouldReturnIt(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchRouteConfig("route_config_0")
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), &resourcev3.RouteConfigResourceData{Resource: &routev3.RouteConfiguration{
+		Name: "route_config_0",
+		Vhds: &routev3.Vhds{},
+	}})
+	answered := make(chan error, 1)
+	go func() { answered <- cache.WatchVirtualHost(context.Background(), "route_config_0", "host_0") }()
+
+	assert.Eventually(t, func() bool {
+		return client.watcher(resourcev3.VirtualHostType, "route_config_0/host_0") != nil
+	}, time.Second, time.Millisecond)
+	client.watcher(resourcev3.VirtualHostType, "route_config_0/host_0").OnError(errors.New("VHDS requires the incremental protocol"))
+
+	select {
+	case err := <-answered:
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "incremental protocol")
+	case <-time.After(time.Second):
+		t.Fatal("virtual host should be answered with the error of its watch")
+	}
+}
+
+// srdsListener returns a listener fetching its scopes through SRDS, from a
+// config source listing the given authorities.
+func srdsListener(t *testing.T, name string, authorities ...string) *resourcev3.ListenerResourceData {
+	source := &corev3.ConfigSource{}
+	for _, authority := range authorities {
+		source.Authorities = append(source.Authorities, &xdscorev3.Authority{Name: authority})
+	}
+	manager, err := anypb.New(&hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_ScopedRoutes{ScopedRoutes: &hcmv3.ScopedRoutes{
+			Name:            "scoped_routes_0",
+			ConfigSpecifier: &hcmv3.ScopedRoutes_ScopedRds{ScopedRds: &hcmv3.ScopedRds{ScopedRdsConfigSource: source}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resourcev3.ListenerResourceData{Resource: &listenerv3.Listener{
+		Name:        name,
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	}}
+}
+
+func TestCache_ScopedRDS_ShouldWatchScopesAndTheirRouteConfigs(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	listeners := client.watcher(resourcev3.ListenerType, "")
+
+	deliver(listeners, srdsListener(t, "listener_0"))
+	scopes := client.watcher(resourcev3.ScopedRouteConfigType, "")
+	if !assert.NotNil(t, scopes, "every scope should be watched") {
+		return
+	}
+	deliver(scopes, &resourcev3.ScopedRouteConfigResourceData{Resource: &routev3.ScopedRouteConfiguration{
+		Name:                   "scope_0",
+		RouteConfigurationName: "route_config_0",
+	}})
+	assert.NotNil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+	if scoped := cache.Snapshot().GetScopedRoutes(); assert.Len(t, scoped, 1) {
+		assert.Len(t, scoped[0].Scopes, 1)
+	}
+
+	deliver(listeners, rdsListener(t, "listener_0", "route_config_1"))
+	assert.Nil(t, client.watcher(resourcev3.ScopedRouteConfigType, ""), "scopes should be released once SRDS is no longer used")
+	assert.Nil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"))
+	assert.Empty(t, cache.Snapshot().GetScopedRoutes())
+}
+
+func TestCache_ScopedRDS_ShouldOnlyGiveListenersTheScopesOfTheirConfigSource(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	deliver(client.watcher(resourcev3.ListenerType, ""), srdsListener(t, "listener_0"), srdsListener(t, "listener_1", "eu"))
+	deliver(client.watcher(resourcev3.ScopedRouteConfigType, ""),
+		&resourcev3.ScopedRouteConfigResourceData{Resource: &routev3.ScopedRouteConfiguration{
+			Name:                   "scope_0",
+			RouteConfigurationName: "route_config_0",
+		}},
+		&resourcev3.ScopedRouteConfigResourceData{Resource: &routev3.ScopedRouteConfiguration{
+			Name:                   "xdstp://eu/envoy.config.route.v3.ScopedRouteConfiguration/scope_1",
+			RouteConfigurationName: "route_config_1",
+		}})
+
+	scoped := cache.Snapshot().GetScopedRoutes()
+	if assert.Len(t, scoped, 2) && assert.Len(t, scoped[0].Scopes, 1) && assert.Len(t, scoped[1].Scopes, 1) {
+		assert.Equal(t, "scope_0", scoped[0].Scopes[0].GetName(), "listener_0 should only get the scopes of its config source")
+		assert.Equal(t, "xdstp://eu/envoy.config.route.v3.ScopedRouteConfiguration/scope_1", scoped[1].Scopes[0].GetName(), "listener_1 should only get the scopes of its authority")
+	}
+}
+
+func inlineListener(t *testing.T, name string, routeConfig *routev3.RouteConfiguration) *resourcev3.ListenerResourceData {
+	manager, err := anypb.New(&hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_RouteConfig{RouteConfig: routeConfig},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resourcev3.ListenerResourceData{Resource: &listenerv3.Listener{
+		Name:        name,
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	}}
+}
+
+func TestCache_InlineRouteConfig_ShouldSwitchWithRDS(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	cache.WatchListener("")
+	listeners := client.watcher(resourcev3.ListenerType, "")
+
+	deliver(listeners, inlineListener(t, "listener_0", routeConfig("route_config_0", "cluster_0").Resource))
+	assert.Len(t, cache.GetRouteConfigs(), 1, "inline route configuration should be stored")
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_0"))
+	assert.Nil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"), "inline route configuration should not be fetched")
+
+	deliver(listeners, rdsListener(t, "listener_0", "route_config_0"))
+	assert.Empty(t, cache.GetRouteConfigs(), "inline route configuration should be evicted")
+	assert.Nil(t, client.watcher(resourcev3.ClusterType, "cluster_0"))
+	deliver(client.watcher(resourcev3.RouteConfigType, "route_config_0"), routeConfig("route_config_0", "cluster_1"))
+	assert.Len(t, cache.GetRouteConfigs(), 1)
+	assert.NotNil(t, client.watcher(resourcev3.ClusterType, "cluster_1"))
+
+	deliver(listeners, inlineListener(t, "listener_0", routeConfig("route_config_0", "cluster_0").Resource))
+	assert.Nil(t, client.watcher(resourcev3.RouteConfigType, "route_config_0"), "route configuration fetched through RDS should be released")
+	assert.Nil(t, client.watcher(resourcev3.ClusterType, "cluster_1"))
+	if routeConfigs := cache.GetRouteConfigs(); assert.Len(t, routeConfigs, 1) {
+		assert.Equal(t, "cluster_0", routeConfigs[0].VirtualHosts[0].Routes[0].GetRoute().GetCluster())
+	}
+}
+
+// collect subscribes to cache and returns the channel receiving the events.
+func collect(cache XDSCache) (<-chan Event, func()) {
+	events := make(chan Event, 16)
+	cancel := cache.Subscribe(func(e Event) { events <- e })
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return Event{}
+	}
+}
+
+func TestSubscribe_Changes_ShouldDeliverTypedEvents(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	defer cache.Close()
+	cache.WatchCluster("")
+	clusters := client.watcher(resourcev3.ClusterType, "")
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+
+	events, cancel := collect(cache)
+	defer cancel()
+	e := nextEvent(t, events)
+	assert.Equal(t, ResourceAdded, e.Type, "held resources should be reported first")
+	assert.Equal(t, resourcev3.ClusterType.TypeURL(), e.TypeURL)
+	assert.Equal(t, "cluster_0", e.Name)
+	assert.Nil(t, e.Old)
+
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0", AltStatName: "v2"}})
+	e = nextEvent(t, events)
+	assert.Equal(t, ResourceUpdated, e.Type, "unchanged resources should not be reported")
+	assert.Equal(t, "", e.Old.(*clusterv3.Cluster).AltStatName)
+	assert.Equal(t, "v2", e.New.(*clusterv3.Cluster).AltStatName)
+
+	clusters.(resourcev3.ResourceRemovedWatcher).OnResourceRemoved("cluster_0")
+	clusters.(resourcev3.BatchWatcher).OnBatchDone()
+	e = nextEvent(t, events)
+	assert.Equal(t, ResourceRemoved, e.Type)
+	assert.Equal(t, "v2", e.Old.(*clusterv3.Cluster).AltStatName)
+	assert.Nil(t, e.New)
+}
+
+func TestSubscribe_Changes_ShouldCarryVersions(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	defer cache.Close()
+	cache.WatchCluster("")
+	clusters := client.watcher(resourcev3.ClusterType, "")
+	setVersion := func(version string) {
+		client.resources = map[string]map[string]resourcev3.UpdateWithMD{
+			resourcev3.ClusterType.TypeURL(): {"cluster_0": {MD: resourcev3.UpdateMetadata{Version: version}}},
+		}
+	}
+	setVersion("1")
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+
+	events, cancel := collect(cache)
+	defer cancel()
+	e := nextEvent(t, events)
+	assert.Equal(t, "", e.OldVersion)
+	assert.Equal(t, "1", e.NewVersion)
+
+	setVersion("2")
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0", AltStatName: "v2"}})
+	e = nextEvent(t, events)
+	assert.Equal(t, ResourceUpdated, e.Type)
+	assert.Equal(t, "1", e.OldVersion)
+	assert.Equal(t, "2", e.NewVersion)
+
+	clusters.(resourcev3.ResourceRemovedWatcher).OnResourceRemoved("cluster_0")
+	clusters.(resourcev3.BatchWatcher).OnBatchDone()
+	e = nextEvent(t, events)
+	assert.Equal(t, ResourceRemoved, e.Type)
+	assert.Equal(t, "2", e.OldVersion)
+	assert.Equal(t, "", e.NewVersion)
+}
+
+func TestSubscribe_SlowSubscriber_ShouldNotBlockUpdates(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	defer cache.Close()
+	cache.WatchCluster("")
+	clusters := client.watcher(resourcev3.ClusterType, "")
+
+	release := make(chan struct{})
+	defer close(release)
+	cancel := cache.Subscribe(func(Event) { <-release })
+	defer cancel()
+
+	updated := make(chan struct{})
+	go func() {
+		deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+		deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_1"}})
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("updates should not wait for subscribers")
+	}
+	_, err := cache.GetCluster("cluster_1")
+	assert.NoError(t, err)
+}
+
+func TestSubscribe_Cancelled_ShouldStopDeliveries(t *testing.T) {
+	client := newFakeClient()
+	cache := New(client)
+	defer cache.Close()
+	cache.WatchCluster("")
+	clusters := client.watcher(resourcev3.ClusterType, "")
+
+	events, cancel := collect(cache)
+	cancel()
+	deliver(clusters, &resourcev3.ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}})
+
+	assert.Never(t, func() bool { return len(events) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestConfigDump_ShouldReportStatusOfEveryResource(t *testing.T) {
+	received := time.Now()
+	listener, err := anypb.New(&listenerv3.Listener{Name: "listener_0"})
+	assert.NoError(t, err)
+	cluster, err := anypb.New(&clusterv3.Cluster{Name: "cluster_0"})
+	assert.NoError(t, err)
+	client := newFakeClient()
+	client.resources = map[string]map[string]resourcev3.UpdateWithMD{
+		resourcev3.ListenerType.TypeURL(): {
+			"listener_0": {Raw: listener, MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusACKed, Version: "1", Timestamp: received}},
+		},
+		resourcev3.RouteConfigType.TypeURL(): {
+			"route_config_0": {MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusRequested}},
+		},
+		resourcev3.ClusterType.TypeURL(): {
+			"cluster_0": {Raw: cluster, MD: resourcev3.UpdateMetadata{
+				Status:   resourcev3.ServiceStatusNACKed,
+				Version:  "1",
+				ErrState: &resourcev3.UpdateErrorMetadata{Version: "2", Err: errors.New("invalid cluster")},
+			}},
+		},
+	}
+
+	dump := New(client).ConfigDump()
+
+	assert.Len(t, dump.Configs, 4)
+	listeners := &adminv3.ListenersConfigDump{}
+	assert.NoError(t, dump.Configs[0].UnmarshalTo(listeners))
+	assert.Equal(t, "listener_0", listeners.DynamicListeners[0].Name)
+	assert.Equal(t, adminv3.ClientResourceStatus_ACKED, listeners.DynamicListeners[0].ClientStatus)
+	assert.Equal(t, "1", listeners.DynamicListeners[0].ActiveState.VersionInfo)
+	assert.Equal(t, received.Unix(), listeners.DynamicListeners[0].ActiveState.LastUpdated.AsTime().Unix())
+	routes := &adminv3.RoutesConfigDump{}
+	assert.NoError(t, dump.Configs[1].UnmarshalTo(routes))
+	assert.Empty(t, routes.DynamicRouteConfigs, "route configurations not received should be left out")
+	clusters := &adminv3.ClustersConfigDump{}
+	assert.NoError(t, dump.Configs[2].UnmarshalTo(clusters))
+	assert.Equal(t, adminv3.ClientResourceStatus_NACKED, clusters.DynamicActiveClusters[0].ClientStatus)
+	assert.Equal(t, "1", clusters.DynamicActiveClusters[0].VersionInfo)
+	assert.Equal(t, "2", clusters.DynamicActiveClusters[0].ErrorState.VersionInfo)
+	assert.Equal(t, "invalid cluster", clusters.DynamicActiveClusters[0].ErrorState.Details)
+}
+
+func TestClientConfig_ShouldReportEveryWatchedResource(t *testing.T) {
+	client := newFakeClient()
+	client.resources = map[string]map[string]resourcev3.UpdateWithMD{
+		resourcev3.RouteConfigType.TypeURL(): {
+			"route_config_0": {MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusRequested}},
+			"route_config_1": {MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusNotExist, Timestamp: time.Now()}},
+		},
+	}
+
+	config := New(client).ClientConfig()
+
+	assert.Equal(t, "node_0", config.Node.GetId())
+	if assert.Len(t, config.GenericXdsConfigs, 2) {
+		assert.Equal(t, "route_config_0", config.GenericXdsConfigs[0].Name)
+		assert.Equal(t, resourcev3.RouteConfigType.TypeURL(), config.GenericXdsConfigs[0].TypeUrl)
+		assert.Equal(t, adminv3.ClientResourceStatus_REQUESTED, config.GenericXdsConfigs[0].ClientStatus)
+		assert.Nil(t, config.GenericXdsConfigs[0].LastUpdated)
+		assert.Equal(t, adminv3.ClientResourceStatus_DOES_NOT_EXIST, config.GenericXdsConfigs[1].ClientStatus)
+	}
+}
@@ -0,0 +1,170 @@
+package xdscache
+
+import (
+	"sort"
+
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	statusv3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// ConfigDump returns the resources received by the xDS client in the shape of
+// the config_dump of the Envoy admin interface: the dynamic listeners, route
+// configurations, clusters and endpoints, with their version, the time they
+// were received and the last NACK. Route configurations and endpoints that
+// have not been received are left out, since their config dumps have no name
+// field to identify them.
+func (x *xdsCache) ConfigDump() *adminv3.ConfigDump {
+	resources := x.xdsClient.DumpResources()
+
+	listeners := &adminv3.ListenersConfigDump{}
+	for _, name := range sortedNames(resources[version.V3ListenerURL]) {
+		r := resources[version.V3ListenerURL][name]
+		listener := &adminv3.ListenersConfigDump_DynamicListener{
+			Name:         name,
+			ErrorState:   ErrorState(r.MD),
+			ClientStatus: ResourceStatus(r.MD.Status),
+		}
+		if r.Raw != nil {
+			listener.ActiveState = &adminv3.ListenersConfigDump_DynamicListenerState{
+				VersionInfo: r.MD.Version,
+				Listener:    r.Raw,
+				LastUpdated: timestamppb.New(r.MD.Timestamp),
+			}
+		}
+		listeners.DynamicListeners = append(listeners.DynamicListeners, listener)
+	}
+
+	routes := &adminv3.RoutesConfigDump{}
+	for _, name := range sortedNames(resources[version.V3RouteConfigURL]) {
+		r := resources[version.V3RouteConfigURL][name]
+		if r.Raw == nil {
+			continue
+		}
+		routes.DynamicRouteConfigs = append(routes.DynamicRouteConfigs, &adminv3.RoutesConfigDump_DynamicRouteConfig{
+			VersionInfo:  r.MD.Version,
+			RouteConfig:  r.Raw,
+			LastUpdated:  timestamppb.New(r.MD.Timestamp),
+			ErrorState:   ErrorState(r.MD),
+			ClientStatus: ResourceStatus(r.MD.Status),
+		})
+	}
+
+	clusters := &adminv3.ClustersConfigDump{}
+	for _, name := range sortedNames(resources[version.V3ClusterURL]) {
+		r := resources[version.V3ClusterURL][name]
+		cluster := &adminv3.ClustersConfigDump_DynamicCluster{
+			VersionInfo:  r.MD.Version,
+			Cluster:      r.Raw,
+			ErrorState:   ErrorState(r.MD),
+			ClientStatus: ResourceStatus(r.MD.Status),
+		}
+		if r.Raw != nil {
+			cluster.LastUpdated = timestamppb.New(r.MD.Timestamp)
+		}
+		clusters.DynamicActiveClusters = append(clusters.DynamicActiveClusters, cluster)
+	}
+
+	endpoints := &adminv3.EndpointsConfigDump{}
+	for _, name := range sortedNames(resources[version.V3EndpointsURL]) {
+		r := resources[version.V3EndpointsURL][name]
+		if r.Raw == nil {
+			continue
+		}
+		endpoints.DynamicEndpointConfigs = append(endpoints.DynamicEndpointConfigs, &adminv3.EndpointsConfigDump_DynamicEndpointConfig{
+			VersionInfo:    r.MD.Version,
+			EndpointConfig: r.Raw,
+			LastUpdated:    timestamppb.New(r.MD.Timestamp),
+			ErrorState:     ErrorState(r.MD),
+			ClientStatus:   ResourceStatus(r.MD.Status),
+		})
+	}
+
+	dump := &adminv3.ConfigDump{}
+	for _, config := range []proto.Message{listeners, routes, clusters, endpoints} {
+		a, err := anypb.New(config)
+		if err != nil {
+			continue
+		}
+		dump.Configs = append(dump.Configs, a)
+	}
+	return dump
+}
+
+// ClientConfig returns the state of every resource watched by the xDS client,
+// received or not, as reported by the Client Status Discovery Service.
+func (x *xdsCache) ClientConfig() *statusv3.ClientConfig {
+	resources := x.xdsClient.DumpResources()
+	typeURLs := make([]string, 0, len(resources))
+	for typeURL := range resources {
+		typeURLs = append(typeURLs, typeURL)
+	}
+	sort.Strings(typeURLs)
+
+	config := &statusv3.ClientConfig{Node: x.xdsClient.Node()}
+	for _, typeURL := range typeURLs {
+		for _, name := range sortedNames(resources[typeURL]) {
+			r := resources[typeURL][name]
+			generic := &statusv3.ClientConfig_GenericXdsConfig{
+				TypeUrl:      typeURL,
+				Name:         name,
+				VersionInfo:  r.MD.Version,
+				XdsConfig:    r.Raw,
+				ClientStatus: ResourceStatus(r.MD.Status),
+				ErrorState:   ErrorState(r.MD),
+			}
+			if !r.MD.Timestamp.IsZero() {
+				generic.LastUpdated = timestamppb.New(r.MD.Timestamp)
+			}
+			config.GenericXdsConfigs = append(config.GenericXdsConfigs, generic)
+		}
+	}
+	return config
+}
+
+// ResourceStatus returns the admin API counterpart of the status recorded by
+// the xDS client.
+func ResourceStatus(status resourcev3.ServiceStatus) adminv3.ClientResourceStatus {
+	switch status {
+	case resourcev3.ServiceStatusRequested:
+		return adminv3.ClientResourceStatus_REQUESTED
+	case resourcev3.ServiceStatusNotExist:
+		return adminv3.ClientResourceStatus_DOES_NOT_EXIST
+	case resourcev3.ServiceStatusACKed:
+		return adminv3.ClientResourceStatus_ACKED
+	case resourcev3.ServiceStatusNACKed:
+		return adminv3.ClientResourceStatus_NACKED
+	default:
+		return adminv3.ClientResourceStatus_UNKNOWN
+	}
+}
+
+// ErrorState returns the admin API form of the last NACK recorded for a
+// resource, nil if it has none.
+func ErrorState(md resourcev3.UpdateMetadata) *adminv3.UpdateFailureState {
+	if md.ErrState == nil {
+		return nil
+	}
+	state := &adminv3.UpdateFailureState{
+		LastUpdateAttempt: timestamppb.New(md.ErrState.Timestamp),
+		VersionInfo:       md.ErrState.Version,
+	}
+	if md.ErrState.Err != nil {
+		state.Details = md.ErrState.Err.Error()
+	}
+	return state
+}
+
+func sortedNames(resources map[string]resourcev3.UpdateWithMD) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
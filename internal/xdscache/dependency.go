@@ -0,0 +1,362 @@
+package xdscache
+
+import (
+	"fmt"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"google.golang.org/protobuf/proto"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
+)
+
+// dependentTypes maps every resource type to the type of the resources it
+// references: listeners reference route configurations, which reference
+// clusters, which reference their endpoints when resolved through EDS.
+// Listeners fetching their scopes through SRDS also hold a reference to the
+// watch of every scope, see setScopedRDSLocked.
+var dependentTypes = map[resourcev3.Type]resourcev3.Type{
+	resourcev3.ListenerType:          resourcev3.RouteConfigType,
+	resourcev3.ScopedRouteConfigType: resourcev3.RouteConfigType,
+	resourcev3.RouteConfigType:       resourcev3.ClusterType,
+	resourcev3.VirtualHostType:       resourcev3.ClusterType,
+	resourcev3.ClusterType:           resourcev3.EndpointsType,
+}
+
+// dependency is a watch started because other resources reference it. It is
+// cancelled once the last of them stops doing so.
+type dependency struct {
+	refs int
+	// cancel is nil until the watch is started.
+	cancel func()
+	// resolved is set once the resource has been received or is known not
+	// to exist.
+	resolved bool
+	// answered fires once the resource and the ones it references have been
+	// resolved and published, for the virtual hosts fetched on demand. It is
+	// nil for the other dependencies.
+	answered *event.Event
+	// err is the error the watch of a virtual host fetched on demand failed
+	// with before it could be answered, until it is resolved.
+	err error
+}
+
+// dependencyOps collects the watches to start and cancel after a change of
+// the dependency graph. They are applied once x.mu is released, since the
+// xDS client may call back into the cache while watching.
+type dependencyOps struct {
+	watches []dependencyWatch
+	cancels []*dependency
+}
+
+type dependencyWatch struct {
+	rType resourcev3.Type
+	name  string
+	dep   *dependency
+}
+
+// setReferencesLocked records that the named resource of type rType now
+// references children, watching the ones it did not reference before and
+// releasing the ones it no longer does. It must be called with x.mu held, s
+// being the pending generation.
+func (x *xdsCache) setReferencesLocked(s *Snapshot, rType resourcev3.Type, name string, children []string, ops *dependencyOps) {
+	childType := dependentTypes[rType]
+	if childType == nil {
+		return
+	}
+
+	old := x.references[rType][name]
+	wanted := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		if _, ok := wanted[child]; ok {
+			continue
+		}
+		wanted[child] = struct{}{}
+		if !contains(old, child) {
+			x.acquireLocked(childType, child, ops)
+		}
+	}
+	for _, child := range old {
+		if _, ok := wanted[child]; !ok {
+			x.releaseLocked(s, childType, child, ops)
+		}
+	}
+
+	if len(wanted) == 0 {
+		delete(x.references[rType], name)
+		return
+	}
+	if x.references[rType] == nil {
+		x.references[rType] = make(map[string][]string)
+	}
+	names := make([]string, 0, len(wanted))
+	for child := range wanted {
+		names = append(names, child)
+	}
+	x.references[rType][name] = names
+}
+
+func (x *xdsCache) acquireLocked(rType resourcev3.Type, name string, ops *dependencyOps) {
+	if dep, ok := x.dependencies[rType][name]; ok {
+		dep.refs++
+		return
+	}
+
+	dep := &dependency{refs: 1}
+	if x.dependencies[rType] == nil {
+		x.dependencies[rType] = make(map[string]*dependency)
+	}
+	x.dependencies[rType][name] = dep
+	ops.watches = append(ops.watches, dependencyWatch{rType: rType, name: name, dep: dep})
+}
+
+// resolveLocked records that the named resource of type rType has been
+// received or is known not to exist, if it is a dependency.
+func (x *xdsCache) resolveLocked(rType resourcev3.Type, name string) {
+	if dep, ok := x.dependencies[rType][name]; ok {
+		dep.resolved = true
+	}
+}
+
+// releaseLocked drops a reference to the named resource. The last one
+// cancels its watch, evicts it and releases what it references in turn.
+func (x *xdsCache) releaseLocked(s *Snapshot, rType resourcev3.Type, name string, ops *dependencyOps) {
+	dep, ok := x.dependencies[rType][name]
+	if !ok {
+		return
+	}
+	dep.refs--
+	if dep.refs > 0 {
+		return
+	}
+
+	delete(x.dependencies[rType], name)
+	ops.cancels = append(ops.cancels, dep)
+	s.remove(rType, name)
+	x.setReferencesLocked(s, rType, name, nil, ops)
+	switch rType {
+	case resourcev3.RouteConfigType:
+		x.releaseVirtualHostsLocked(s, name, ops)
+	case resourcev3.ScopedRouteConfigType:
+		x.releaseScopesLocked(s, ops)
+	}
+}
+
+// releaseVirtualHostsLocked drops the virtual hosts fetched on demand for the
+// named route configuration, once it is gone or no longer enables VHDS.
+func (x *xdsCache) releaseVirtualHostsLocked(s *Snapshot, routeConfigName string, ops *dependencyOps) {
+	for _, name := range x.onDemand[routeConfigName] {
+		x.releaseLocked(s, resourcev3.VirtualHostType, name, ops)
+	}
+	delete(x.onDemand, routeConfigName)
+}
+
+// setScopedRDSLocked records whether the named listener fetches scopes
+// through SRDS. Every ScopedRouteConfiguration is watched, under the empty
+// name, while a listener does.
+func (x *xdsCache) setScopedRDSLocked(s *Snapshot, listenerName string, enabled bool, ops *dependencyOps) {
+	_, was := x.srdsListeners[listenerName]
+	switch {
+	case enabled && !was:
+		x.srdsListeners[listenerName] = struct{}{}
+		x.acquireLocked(resourcev3.ScopedRouteConfigType, "", ops)
+	case !enabled && was:
+		delete(x.srdsListeners, listenerName)
+		x.releaseLocked(s, resourcev3.ScopedRouteConfigType, "", ops)
+	}
+}
+
+// setInlineRouteConfigsLocked stores the route configurations the named
+// listener holds inline, keyed by the name they are stored under, and watches
+// the clusters they route to. The ones the listener no longer holds are
+// evicted, and their clusters released.
+func (x *xdsCache) setInlineRouteConfigsLocked(s *Snapshot, listenerName string, routeConfigs map[string]*routev3.RouteConfiguration, ops *dependencyOps) {
+	for name, rc := range routeConfigs {
+		s.routeConfigs[name] = rc
+		x.setReferencesLocked(s, resourcev3.RouteConfigType, name, clusterNames(rc.GetVirtualHosts()...), ops)
+	}
+	for _, name := range x.inline[listenerName] {
+		if _, ok := routeConfigs[name]; !ok {
+			s.remove(resourcev3.RouteConfigType, name)
+			x.setReferencesLocked(s, resourcev3.RouteConfigType, name, nil, ops)
+		}
+	}
+
+	if len(routeConfigs) == 0 {
+		delete(x.inline, listenerName)
+		return
+	}
+	names := make([]string, 0, len(routeConfigs))
+	for name := range routeConfigs {
+		names = append(names, name)
+	}
+	x.inline[listenerName] = names
+}
+
+// releaseScopesLocked drops the scopes fetched through SRDS, and the route
+// configurations they reference, once no listener uses SRDS anymore.
+func (x *xdsCache) releaseScopesLocked(s *Snapshot, ops *dependencyOps) {
+	for name := range s.scopedRouteConfigs {
+		s.remove(resourcev3.ScopedRouteConfigType, name)
+		x.setReferencesLocked(s, resourcev3.ScopedRouteConfigType, name, nil, ops)
+	}
+}
+
+// resolvedTreeLocked reports whether the named resource and, transitively,
+// the resources it references have all been resolved.
+func (x *xdsCache) resolvedTreeLocked(rType resourcev3.Type, name string) bool {
+	dep, ok := x.dependencies[rType][name]
+	if !ok || !dep.resolved {
+		return false
+	}
+	for _, ref := range x.references[rType][name] {
+		if !x.resolvedTreeLocked(dependentTypes[rType], ref) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply starts and cancels the watches collected in ops. A watch released
+// before it could be started is cancelled right away.
+func (x *xdsCache) apply(ops *dependencyOps) {
+	for _, w := range ops.watches {
+		cancel := x.xdsClient.WatchResource(w.rType, w.name, x.newWatcher(w.rType, w.name))
+
+		x.mu.Lock()
+		released := w.dep.refs == 0
+		w.dep.cancel = cancel
+		x.mu.Unlock()
+
+		if released {
+			cancel()
+		}
+	}
+
+	for _, dep := range ops.cancels {
+		x.mu.Lock()
+		cancel := dep.cancel
+		x.mu.Unlock()
+
+		if cancel != nil {
+			cancel()
+		}
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// httpConnManagers returns the HTTP connection managers of listener, either
+// as its API listener or in its filter chains.
+func httpConnManagers(listener *listenerv3.Listener) []*hcmv3.HttpConnectionManager {
+	var managers []*hcmv3.HttpConnectionManager
+	if api := listener.GetApiListener().GetApiListener(); api != nil {
+		if manager, ok := httpConnManager(api.GetTypeUrl(), api.GetValue()); ok {
+			managers = append(managers, manager)
+		}
+	}
+	for _, filterChain := range listener.GetFilterChains() {
+		for _, filter := range filterChain.GetFilters() {
+			typedConfig := filter.GetTypedConfig()
+			if manager, ok := httpConnManager(typedConfig.GetTypeUrl(), typedConfig.GetValue()); ok {
+				managers = append(managers, manager)
+			}
+		}
+	}
+	return managers
+}
+
+// routeConfigNames returns the route configurations the HTTP connection
+// managers fetch through RDS, including those of the scopes they list.
+func routeConfigNames(managers []*hcmv3.HttpConnectionManager) []string {
+	var names []string
+	for _, manager := range managers {
+		if name := manager.GetRds().GetRouteConfigName(); name != "" {
+			names = append(names, name)
+		}
+		for _, scope := range manager.GetScopedRoutes().GetScopedRouteConfigurationsList().GetScopedRouteConfigurations() {
+			names = append(names, scope.GetRouteConfigurationName())
+		}
+	}
+	return names
+}
+
+// inlineRouteConfigs returns the route configurations the HTTP connection
+// managers of the named listener hold inline. Inline route configurations
+// need not be named, and may share the name of one fetched through RDS, so
+// they are keyed by the name they are stored under in the cache: the listener
+// name followed by the index of their connection manager.
+func inlineRouteConfigs(listenerName string, managers []*hcmv3.HttpConnectionManager) map[string]*routev3.RouteConfiguration {
+	routeConfigs := make(map[string]*routev3.RouteConfiguration)
+	for i, manager := range managers {
+		if rc := manager.GetRouteConfig(); rc != nil {
+			routeConfigs[fmt.Sprintf("%s/%d", listenerName, i)] = rc
+		}
+	}
+	return routeConfigs
+}
+
+// scopedRoutes returns the scoped routes of the HTTP connection managers
+// routing through them, and whether one of them fetches its scopes through
+// SRDS.
+func scopedRoutes(managers []*hcmv3.HttpConnectionManager) (scoped []*hcmv3.ScopedRoutes, srds bool) {
+	for _, manager := range managers {
+		if s := manager.GetScopedRoutes(); s != nil {
+			scoped = append(scoped, s)
+			srds = srds || s.GetScopedRds() != nil
+		}
+	}
+	return scoped, srds
+}
+
+// httpConnManager unmarshals an HttpConnectionManager, reporting false if the
+// config is of another filter.
+func httpConnManager(typeURL string, value []byte) (*hcmv3.HttpConnectionManager, bool) {
+	if !resourcev3.IsHTTPConnManagerResource(typeURL) {
+		return nil, false
+	}
+	manager := &hcmv3.HttpConnectionManager{}
+	if err := proto.Unmarshal(value, manager); err != nil {
+		return nil, false
+	}
+	return manager, true
+}
+
+// clusterNames returns the clusters the virtual hosts route to. Only the
+// clusters named by route actions, directly or as weighted clusters, are
+// followed: clusters picked from a request header cannot be known in advance.
+func clusterNames(virtualHosts ...*routev3.VirtualHost) []string {
+	var names []string
+	for _, vh := range virtualHosts {
+		for _, route := range vh.GetRoutes() {
+			if name := route.GetRoute().GetCluster(); name != "" {
+				names = append(names, name)
+			}
+			for _, cluster := range route.GetRoute().GetWeightedClusters().GetClusters() {
+				if cluster.GetName() != "" {
+					names = append(names, cluster.GetName())
+				}
+			}
+		}
+	}
+	return names
+}
+
+// endpointsNames returns the ClusterLoadAssignment cluster fetches through EDS,
+// if any.
+func endpointsNames(cluster *clusterv3.Cluster) []string {
+	if cluster.GetType() != clusterv3.Cluster_EDS {
+		return nil
+	}
+	return []string{EDSServiceName(cluster)}
+}
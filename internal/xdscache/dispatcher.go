@@ -0,0 +1,66 @@
+package xdscache
+
+import (
+	"sync"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
+)
+
+// dispatcher runs callbacks one at a time, in the order they were scheduled,
+// on a goroutine of its own. Scheduling never blocks, so the xDS streams do
+// not wait for slow subscribers.
+type dispatcher struct {
+	mu    sync.Mutex
+	queue []func()
+	wake  chan struct{}
+	done  *event.Event
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{
+		wake: make(chan struct{}, 1),
+		done: event.NewEvent(),
+	}
+	go d.run()
+	return d
+}
+
+// schedule queues fn. It is dropped if the dispatcher is closed.
+func (d *dispatcher) schedule(fn func()) {
+	d.mu.Lock()
+	d.queue = append(d.queue, fn)
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dispatcher) run() {
+	for {
+		select {
+		case <-d.done.Done():
+			return
+		case <-d.wake:
+		}
+
+		d.mu.Lock()
+		queue := d.queue
+		d.queue = nil
+		d.mu.Unlock()
+
+		for _, fn := range queue {
+			if d.done.HasFired() {
+				return
+			}
+			fn()
+		}
+	}
+}
+
+// close stops the dispatcher. The callback running, if any, completes but the
+// queued ones are dropped.
+func (d *dispatcher) close() {
+	d.done.Fire()
+}
@@ -0,0 +1,114 @@
+package xdscache
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// EventType tells how a resource changed.
+type EventType int
+
+const (
+	ResourceAdded EventType = iota
+	ResourceUpdated
+	ResourceRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ResourceAdded:
+		return "added"
+	case ResourceUpdated:
+		return "updated"
+	case ResourceRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes the change of a single resource between two generations of
+// the cache. Old is nil when the resource was added and New is nil when it
+// was removed. Both must not be modified.
+type Event struct {
+	Type    EventType
+	TypeURL string
+	Name    string
+	Old     proto.Message
+	New     proto.Message
+	// OldVersion and NewVersion are the versions of Old and New as sent by
+	// the management server, empty when there is no such resource. Route
+	// configurations sent inline have the version of their listener.
+	OldVersion string
+	NewVersion string
+}
+
+// diff returns the events turning s into next.
+func (s *Snapshot) diff(next *Snapshot) []Event {
+	var events []Event
+	events = diffMap(events, resourcev3.ListenerType.TypeURL(), s.listeners, next.listeners)
+	events = diffMap(events, resourcev3.RouteConfigType.TypeURL(), s.routeConfigs, next.routeConfigs)
+	events = diffMap(events, resourcev3.ClusterType.TypeURL(), s.clusters, next.clusters)
+	events = diffMap(events, resourcev3.EndpointsType.TypeURL(), s.clusterLoadAssignments, next.clusterLoadAssignments)
+	events = diffMap(events, resourcev3.VirtualHostType.TypeURL(), s.virtualHosts, next.virtualHosts)
+	events = diffMap(events, resourcev3.ScopedRouteConfigType.TypeURL(), s.scopedRouteConfigs, next.scopedRouteConfigs)
+	return events
+}
+
+// added returns the events adding the content of s to an empty cache.
+func (s *Snapshot) added() []Event {
+	events := newSnapshot().diff(s)
+	for i := range events {
+		events[i].NewVersion = s.versions[versionKey(events[i].TypeURL, events[i].Name)]
+	}
+	return events
+}
+
+// setVersions records in next the versions of the resources changed by
+// events, taken from resources as dumped by the xDS client, and fills the
+// versions of the events. inline maps the name of every inline route
+// configuration to its listener.
+func (s *Snapshot) setVersions(next *Snapshot, events []Event, resources map[string]map[string]resourcev3.UpdateWithMD, inline map[string]string) {
+	for i := range events {
+		e := &events[i]
+		key := versionKey(e.TypeURL, e.Name)
+		e.OldVersion = s.versions[key]
+		if e.Type == ResourceRemoved {
+			delete(next.versions, key)
+			continue
+		}
+		if listener, ok := inline[e.Name]; ok && e.TypeURL == resourcev3.RouteConfigType.TypeURL() {
+			e.NewVersion = resources[resourcev3.ListenerType.TypeURL()][listener].MD.Version
+		} else {
+			e.NewVersion = resources[e.TypeURL][e.Name].MD.Version
+		}
+		next.versions[key] = e.NewVersion
+	}
+}
+
+// versionKey is the key of the version of a resource in Snapshot.versions.
+func versionKey(typeURL, name string) string {
+	return typeURL + "|" + name
+}
+
+// diffMap appends to events the changes between the resources of a type in
+// two generations. Generations share the resources they did not replace, so
+// those are skipped without being compared.
+func diffMap[T proto.Message](events []Event, typeURL string, old, next map[string]T) []Event {
+	for name, n := range next {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: ResourceAdded, TypeURL: typeURL, Name: name, New: n})
+		case any(o) != any(n) && !proto.Equal(o, n):
+			events = append(events, Event{Type: ResourceUpdated, TypeURL: typeURL, Name: name, Old: o, New: n})
+		}
+	}
+	for name, o := range old {
+		if _, ok := next[name]; !ok {
+			events = append(events, Event{Type: ResourceRemoved, TypeURL: typeURL, Name: name, Old: o})
+		}
+	}
+	return events
+}
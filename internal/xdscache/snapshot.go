@@ -0,0 +1,229 @@
+package xdscache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// Snapshot is one generation of the cache content. It is never modified once
+// published, so it can be read from any goroutine without locking, and every
+// lookup made on the same Snapshot sees the same listeners, routes, clusters
+// and endpoints.
+type Snapshot struct {
+	listeners              map[string]*listenerv3.Listener
+	routeConfigs           map[string]*routev3.RouteConfiguration
+	clusters               map[string]*clusterv3.Cluster
+	virtualHosts           map[string]*routev3.VirtualHost
+	scopedRouteConfigs     map[string]*routev3.ScopedRouteConfiguration
+	clusterLoadAssignments map[string]*endpointv3.ClusterLoadAssignment
+	// scopedRoutes holds the scoped routes of the HTTP connection managers
+	// of each listener routing through them, keyed by listener name.
+	scopedRoutes map[string][]*hcmv3.ScopedRoutes
+	// versions holds the version of every resource above, keyed by
+	// versionKey.
+	versions map[string]string
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		listeners:              make(map[string]*listenerv3.Listener),
+		routeConfigs:           make(map[string]*routev3.RouteConfiguration),
+		clusters:               make(map[string]*clusterv3.Cluster),
+		virtualHosts:           make(map[string]*routev3.VirtualHost),
+		scopedRouteConfigs:     make(map[string]*routev3.ScopedRouteConfiguration),
+		clusterLoadAssignments: make(map[string]*endpointv3.ClusterLoadAssignment),
+		scopedRoutes:           make(map[string][]*hcmv3.ScopedRoutes),
+		versions:               make(map[string]string),
+	}
+}
+
+// clone returns a copy of s that can be modified before being published.
+func (s *Snapshot) clone() *Snapshot {
+	return &Snapshot{
+		listeners:              cloneMap(s.listeners),
+		routeConfigs:           cloneMap(s.routeConfigs),
+		clusters:               cloneMap(s.clusters),
+		virtualHosts:           cloneMap(s.virtualHosts),
+		scopedRouteConfigs:     cloneMap(s.scopedRouteConfigs),
+		clusterLoadAssignments: cloneMap(s.clusterLoadAssignments),
+		scopedRoutes:           cloneMap(s.scopedRoutes),
+		versions:               cloneMap(s.versions),
+	}
+}
+
+// cloneMap copies m. The resources are shared, so they must be replaced
+// rather than modified in place.
+func cloneMap[T any](m map[string]T) map[string]T {
+	c := make(map[string]T, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// remove evicts the named resource of type rType.
+func (s *Snapshot) remove(rType resourcev3.Type, name string) {
+	switch rType {
+	case resourcev3.ListenerType:
+		delete(s.listeners, name)
+		delete(s.scopedRoutes, name)
+	case resourcev3.RouteConfigType:
+		delete(s.routeConfigs, name)
+	case resourcev3.ClusterType:
+		delete(s.clusters, name)
+	case resourcev3.EndpointsType:
+		delete(s.clusterLoadAssignments, name)
+	case resourcev3.VirtualHostType:
+		delete(s.virtualHosts, name)
+	case resourcev3.ScopedRouteConfigType:
+		delete(s.scopedRouteConfigs, name)
+	}
+}
+
+func (s *Snapshot) GetListener(name string) (*listenerv3.Listener, error) {
+	resource, exists := s.listeners[name]
+	if !exists {
+		return nil, fmt.Errorf("resource not found")
+	}
+
+	return resource, nil
+}
+func (s *Snapshot) GetRouteConfig(name string) (*routev3.RouteConfiguration, error) {
+	resource, exists := s.routeConfigs[name]
+	if !exists {
+		return nil, fmt.Errorf("resource not found")
+	}
+
+	return resource, nil
+}
+
+// GetRouteConfigs returns every route configuration.
+func (s *Snapshot) GetRouteConfigs() []*routev3.RouteConfiguration {
+	resources := make([]*routev3.RouteConfiguration, 0, len(s.routeConfigs))
+	for _, resource := range s.routeConfigs {
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// GetVirtualHosts returns the virtual hosts of routeConfig, followed by the
+// ones fetched on demand through VHDS, sorted by name.
+func (s *Snapshot) GetVirtualHosts(routeConfig *routev3.RouteConfiguration) []*routev3.VirtualHost {
+	if routeConfig.GetVhds() == nil {
+		return routeConfig.GetVirtualHosts()
+	}
+	var names []string
+	for name := range s.virtualHosts {
+		if resourcev3.IsVirtualHostOf(name, routeConfig.GetName()) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return routeConfig.GetVirtualHosts()
+	}
+	sort.Strings(names)
+	virtualHosts := append([]*routev3.VirtualHost(nil), routeConfig.GetVirtualHosts()...)
+	for _, name := range names {
+		virtualHosts = append(virtualHosts, s.virtualHosts[name])
+	}
+	return virtualHosts
+}
+
+// ScopedRoutes are the scopes an HTTP connection manager picks the route
+// configuration of a request from, and how it builds the scope key of the
+// request.
+type ScopedRoutes struct {
+	KeyBuilder *hcmv3.ScopedRoutes_ScopeKeyBuilder
+	Scopes     []*routev3.ScopedRouteConfiguration
+}
+
+// GetScopedRoutes returns the scoped routes of the HTTP connection managers
+// of every listener, ordered by listener name. The scopes fetched through
+// SRDS, sorted by name, are only given to the connection managers whose
+// scoped_rds config source they come from: the ones named
+// xdstp://<authority>/... come from the config sources listing that
+// authority, the others from the config sources listing none.
+func (s *Snapshot) GetScopedRoutes() []ScopedRoutes {
+	if len(s.scopedRoutes) == 0 {
+		return nil
+	}
+	listenerNames := make([]string, 0, len(s.scopedRoutes))
+	for name := range s.scopedRoutes {
+		listenerNames = append(listenerNames, name)
+	}
+	sort.Strings(listenerNames)
+	scopeNames := make([]string, 0, len(s.scopedRouteConfigs))
+	for name := range s.scopedRouteConfigs {
+		scopeNames = append(scopeNames, name)
+	}
+	sort.Strings(scopeNames)
+
+	var result []ScopedRoutes
+	for _, listenerName := range listenerNames {
+		for _, scoped := range s.scopedRoutes[listenerName] {
+			scopes := scoped.GetScopedRouteConfigurationsList().GetScopedRouteConfigurations()
+			if srds := scoped.GetScopedRds(); srds != nil {
+				authorities := configSourceAuthorities(srds.GetScopedRdsConfigSource())
+				scopes = make([]*routev3.ScopedRouteConfiguration, 0, len(scopeNames))
+				for _, name := range scopeNames {
+					if authorities[resourceAuthority(name)] {
+						scopes = append(scopes, s.scopedRouteConfigs[name])
+					}
+				}
+			}
+			result = append(result, ScopedRoutes{KeyBuilder: scoped.GetScopeKeyBuilder(), Scopes: scopes})
+		}
+	}
+	return result
+}
+
+// configSourceAuthorities returns the authorities the resources fetched
+// through source are named under, the empty one standing for the names that
+// are not xdstp:// ones.
+func configSourceAuthorities(source *corev3.ConfigSource) map[string]bool {
+	authorities := make(map[string]bool)
+	for _, authority := range source.GetAuthorities() {
+		authorities[authority.GetName()] = true
+	}
+	if len(authorities) == 0 {
+		authorities[""] = true
+	}
+	return authorities
+}
+
+// resourceAuthority returns the authority of an xdstp:// resource name, and an
+// empty string for other names.
+func resourceAuthority(name string) string {
+	if !strings.HasPrefix(name, "xdstp://") {
+		return ""
+	}
+	authority, _, _ := strings.Cut(strings.TrimPrefix(name, "xdstp://"), "/")
+	return authority
+}
+
+func (s *Snapshot) GetCluster(name string) (*clusterv3.Cluster, error) {
+	resource, exists := s.clusters[name]
+	if !exists {
+		return nil, fmt.Errorf("resource not found")
+	}
+
+	return resource, nil
+}
+func (s *Snapshot) GetClusterLoadAssignment(name string) (*endpointv3.ClusterLoadAssignment, error) {
+	resource, exists := s.clusterLoadAssignments[name]
+	if !exists {
+		return nil, fmt.Errorf("resource not found")
+	}
+
+	return resource, nil
+}
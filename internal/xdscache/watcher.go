@@ -0,0 +1,36 @@
+package xdscache
+
+import (
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// watcher forwards the events of a single watch to the cache. name is the
+// watched resource name, empty when every resource of the type is watched.
+// The changes of a response are published once it has been delivered.
+type watcher struct {
+	name      string
+	onUpdate  func(resourcev3.ResourceData)
+	onError   func(error)
+	onRemoved func(name string)
+	onDone    func()
+}
+
+func (w *watcher) OnUpdate(data resourcev3.ResourceData) {
+	w.onUpdate(data)
+}
+
+func (w *watcher) OnError(err error) {
+	w.onError(err)
+}
+
+func (w *watcher) OnResourceDoesNotExist() {
+	w.onRemoved(w.name)
+}
+
+func (w *watcher) OnResourceRemoved(name string) {
+	w.onRemoved(name)
+}
+
+func (w *watcher) OnBatchDone() {
+	w.onDone()
+}
@@ -0,0 +1,247 @@
+package xdsclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/google"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// BootstrapFileNameEnv is the environment variable holding the path of
+	// the bootstrap file, shared with proxyless gRPC.
+	BootstrapFileNameEnv = "GRPC_XDS_BOOTSTRAP"
+	// BootstrapFileContentEnv is the environment variable holding the
+	// bootstrap file content itself. BootstrapFileNameEnv takes precedence.
+	BootstrapFileContentEnv = "GRPC_XDS_BOOTSTRAP_CONFIG"
+)
+
+// Authority is the configuration of an xDS federation authority, as listed
+// under "authorities" in the bootstrap file.
+type Authority struct {
+	// ClientListenerResourceNameTemplate is the template of listener names
+	// requested from this authority. It defaults to
+	// "xdstp://<authority>/envoy.config.listener.v3.Listener/%s".
+	ClientListenerResourceNameTemplate string
+	// XDSServer is the management server of the authority. It is nil when
+	// the authority uses the top-level server. Only its ServerURI, Creds,
+	// ServerFeatures and FallbackServers are used, the other settings being
+	// those of the top-level config.
+	XDSServer *ServerConfig
+}
+
+// CertProviderConfig is a certificate provider plugin instance, as listed
+// under "certificate_providers" in the bootstrap file. Config is kept raw
+// since its content depends on the plugin.
+type CertProviderConfig struct {
+	PluginName string
+	Config     json.RawMessage
+}
+
+type channelCreds struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// tlsCredsConfig is the config of the "tls" channel credentials.
+type tlsCredsConfig struct {
+	CACertificateFile string `json:"ca_certificate_file"`
+	CertificateFile   string `json:"certificate_file"`
+	PrivateKeyFile    string `json:"private_key_file"`
+}
+
+type callCreds struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// jwtTokenFileConfig is the config of the "jwt_token_file" call credentials.
+type jwtTokenFileConfig struct {
+	JWTTokenFile string `json:"jwt_token_file"`
+}
+
+type xdsServer struct {
+	ServerURI      string         `json:"server_uri"`
+	ChannelCreds   []channelCreds `json:"channel_creds"`
+	CallCreds      []callCreds    `json:"call_creds"`
+	ServerFeatures []string       `json:"server_features"`
+}
+
+type authority struct {
+	ClientListenerResourceNameTemplate string      `json:"client_listener_resource_name_template"`
+	XDSServers                         []xdsServer `json:"xds_servers"`
+}
+
+type certProvider struct {
+	PluginName string          `json:"plugin_name"`
+	Config     json.RawMessage `json:"config"`
+}
+
+type bootstrap struct {
+	XDSServers           []xdsServer             `json:"xds_servers"`
+	Node                 json.RawMessage         `json:"node"`
+	Authorities          map[string]authority    `json:"authorities"`
+	CertificateProviders map[string]certProvider `json:"certificate_providers"`
+}
+
+// NewConfigFromBootstrap returns the configuration described by the bootstrap
+// file found at ${GRPC_XDS_BOOTSTRAP}, or by the bootstrap content held in
+// ${GRPC_XDS_BOOTSTRAP_CONFIG}.
+func NewConfigFromBootstrap() (ServerConfig, error) {
+	if fileName := os.Getenv(BootstrapFileNameEnv); fileName != "" {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return ServerConfig{}, fmt.Errorf("fail to read bootstrap file: %w", err)
+		}
+		return NewConfigFromBootstrapContents(data)
+	}
+	if content := os.Getenv(BootstrapFileContentEnv); content != "" {
+		return NewConfigFromBootstrapContents([]byte(content))
+	}
+
+	return ServerConfig{}, fmt.Errorf("none of the bootstrap environment variables (%q or %q) defined", BootstrapFileNameEnv, BootstrapFileContentEnv)
+}
+
+// NewConfigFromBootstrapContents parses a bootstrap file in the format used by
+// proxyless gRPC. The first management server listed is the one connected to,
+// the next ones are failed over to while it is unreachable.
+func NewConfigFromBootstrapContents(data []byte) (ServerConfig, error) {
+	var b bootstrap
+	if err := json.Unmarshal(data, &b); err != nil {
+		return ServerConfig{}, fmt.Errorf("fail to parse bootstrap: %w", err)
+	}
+
+	if len(b.XDSServers) == 0 {
+		return ServerConfig{}, fmt.Errorf("required field %q not found in bootstrap", "xds_servers")
+	}
+	config, err := newServerConfigs(b.XDSServers)
+	if err != nil {
+		return ServerConfig{}, err
+	}
+
+	if len(b.Node) > 0 {
+		node := &corev3.Node{}
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b.Node, node); err != nil {
+			return ServerConfig{}, fmt.Errorf("fail to parse bootstrap node: %w", err)
+		}
+		config.Node = node
+	}
+	// Proxyless gRPC only sends the node on the first request of a stream.
+	config.SetNodeOnFirstMessageOnly = true
+
+	if len(b.Authorities) > 0 {
+		config.Authorities = make(map[string]*Authority, len(b.Authorities))
+	}
+	for name, a := range b.Authorities {
+		auth := &Authority{ClientListenerResourceNameTemplate: a.ClientListenerResourceNameTemplate}
+		if auth.ClientListenerResourceNameTemplate == "" {
+			auth.ClientListenerResourceNameTemplate = fmt.Sprintf("xdstp://%s/envoy.config.listener.v3.Listener/%%s", name)
+		}
+		if len(a.XDSServers) > 0 {
+			server, err := newServerConfigs(a.XDSServers)
+			if err != nil {
+				return ServerConfig{}, fmt.Errorf("authority %q: %w", name, err)
+			}
+			auth.XDSServer = &server
+		}
+		config.Authorities[name] = auth
+	}
+
+	if len(b.CertificateProviders) > 0 {
+		config.CertProviderConfigs = make(map[string]*CertProviderConfig, len(b.CertificateProviders))
+	}
+	for name, p := range b.CertificateProviders {
+		config.CertProviderConfigs[name] = &CertProviderConfig{PluginName: p.PluginName, Config: p.Config}
+	}
+
+	return config, nil
+}
+
+// newServerConfigs returns the configuration of the first of servers, with the
+// next ones as its fallback servers.
+func newServerConfigs(servers []xdsServer) (ServerConfig, error) {
+	config, err := newServerConfig(servers[0])
+	if err != nil {
+		return ServerConfig{}, err
+	}
+	for _, server := range servers[1:] {
+		fallback, err := newServerConfig(server)
+		if err != nil {
+			return ServerConfig{}, err
+		}
+		config.FallbackServers = append(config.FallbackServers, fallback)
+	}
+	return config, nil
+}
+
+func newServerConfig(server xdsServer) (ServerConfig, error) {
+	if server.ServerURI == "" {
+		return ServerConfig{}, fmt.Errorf("required field %q not found in bootstrap", "xds_servers.server_uri")
+	}
+
+	config := ServerConfig{
+		ServerURI:      server.ServerURI,
+		ServerFeatures: server.ServerFeatures,
+	}
+	// The first supported credential type is used.
+	for _, cc := range server.ChannelCreds {
+		creds, ok, err := channelCredsDialOption(cc)
+		if err != nil {
+			return ServerConfig{}, fmt.Errorf("invalid %q channel credentials for %q: %w", cc.Type, server.ServerURI, err)
+		}
+		if !ok {
+			log.Warn().Str("type", cc.Type).Msg("ignoring unsupported channel credentials")
+			continue
+		}
+		config.Creds = creds
+		break
+	}
+	if config.Creds == nil {
+		return ServerConfig{}, fmt.Errorf("no supported channel credentials found for %q", server.ServerURI)
+	}
+	for _, cc := range server.CallCreds {
+		if cc.Type != "jwt_token_file" {
+			log.Warn().Str("type", cc.Type).Msg("ignoring unsupported call credentials")
+			continue
+		}
+		var c jwtTokenFileConfig
+		if err := json.Unmarshal(cc.Config, &c); err != nil || c.JWTTokenFile == "" {
+			return ServerConfig{}, fmt.Errorf("invalid %q call credentials for %q: jwt_token_file not set", cc.Type, server.ServerURI)
+		}
+		config.CallCreds = NewTokenFileCredentials(c.JWTTokenFile)
+		break
+	}
+
+	return config, nil
+}
+
+// channelCredsDialOption returns the dial option of cc, and false if its type
+// is not supported.
+func channelCredsDialOption(cc channelCreds) (grpc.DialOption, bool, error) {
+	switch cc.Type {
+	case "insecure":
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), true, nil
+	case "google_default":
+		return grpc.WithCredentialsBundle(google.NewDefaultCredentials()), true, nil
+	case "tls":
+		var c tlsCredsConfig
+		if len(cc.Config) > 0 {
+			if err := json.Unmarshal(cc.Config, &c); err != nil {
+				return nil, false, err
+			}
+		}
+		creds, err := NewTLSCredentials(c.CACertificateFile, c.CertificateFile, c.PrivateKeyFile)
+		if err != nil {
+			return nil, false, err
+		}
+		return grpc.WithTransportCredentials(creds), true, nil
+	default:
+		return nil, false, nil
+	}
+}
@@ -0,0 +1,115 @@
+package xdsclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testBootstrap = `{
+	"xds_servers": [{
+		"server_uri": "istiod.istio-system.svc:15010",
+		"channel_creds": [{"type": "unknown"}, {"type": "insecure"}],
+		"server_features": ["xds_v3"]
+	}, {
+		"server_uri": "fallback:15010",
+		"channel_creds": [{"type": "insecure"}]
+	}],
+	"node": {
+		"id": "sidecar~10.0.0.1~app.default~default.svc.cluster.local",
+		"cluster": "app",
+		"metadata": {"NAMESPACE": "default", "GENERATOR": "grpc"},
+		"locality": {"region": "us-central1", "zone": "us-central1-a"}
+	},
+	"authorities": {
+		"eu": {
+			"xds_servers": [{"server_uri": "eu:15010", "channel_creds": [{"type": "insecure"}]}]
+		},
+		"local": {}
+	},
+	"certificate_providers": {
+		"default": {"plugin_name": "file_watcher", "config": {"certificate_file": "/var/run/cert.pem"}}
+	}
+}`
+
+func TestNewConfigFromBootstrapContents_ShouldParseEveryBlock(t *testing.T) {
+	config, err := NewConfigFromBootstrapContents([]byte(testBootstrap))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "istiod.istio-system.svc:15010", config.ServerURI)
+	assert.NotNil(t, config.Creds)
+	assert.Equal(t, []string{"xds_v3"}, config.ServerFeatures)
+	if assert.Len(t, config.FallbackServers, 1) {
+		assert.Equal(t, "fallback:15010", config.FallbackServers[0].ServerURI)
+		assert.NotNil(t, config.FallbackServers[0].Creds)
+	}
+
+	assert.Equal(t, "sidecar~10.0.0.1~app.default~default.svc.cluster.local", config.Node.GetId())
+	assert.True(t, config.SetNodeOnFirstMessageOnly)
+	assert.Equal(t, "app", config.Node.GetCluster())
+	assert.Equal(t, "grpc", config.Node.GetMetadata().GetFields()["GENERATOR"].GetStringValue())
+	assert.Equal(t, "us-central1-a", config.Node.GetLocality().GetZone())
+
+	assert.Equal(t, "eu:15010", config.Authorities["eu"].XDSServer.ServerURI)
+	assert.Equal(t, "xdstp://eu/envoy.config.listener.v3.Listener/%s", config.Authorities["eu"].ClientListenerResourceNameTemplate)
+	assert.Nil(t, config.Authorities["local"].XDSServer)
+
+	assert.Equal(t, "file_watcher", config.CertProviderConfigs["default"].PluginName)
+}
+
+func TestNewConfigFromBootstrapContents_NoServer_ShouldFail(t *testing.T) {
+	_, err := NewConfigFromBootstrapContents([]byte(`{"node": {"id": "a"}}`))
+
+	assert.Error(t, err)
+}
+
+func TestNewConfigFromBootstrapContents_NoSupportedCreds_ShouldFail(t *testing.T) {
+	_, err := NewConfigFromBootstrapContents([]byte(`{"xds_servers": [{"server_uri": "a", "channel_creds": [{"type": "unknown"}]}]}`))
+
+	assert.Error(t, err)
+}
+
+func TestNewConfigFromBootstrapContents_TLSAndCallCreds_ShouldLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	caFile, tokenFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "token")
+	writeFile(t, caFile, newTestCA(t).pem, time.Now())
+	writeFile(t, tokenFile, []byte("token"), time.Now())
+
+	config, err := NewConfigFromBootstrapContents([]byte(`{"xds_servers": [{
+		"server_uri": "istiod.istio-system.svc:15012",
+		"channel_creds": [{"type": "tls", "config": {"ca_certificate_file": "` + caFile + `"}}],
+		"call_creds": [{"type": "jwt_token_file", "config": {"jwt_token_file": "` + tokenFile + `"}}]
+	}]}`))
+
+	assert.NoError(t, err)
+	assert.NotNil(t, config.Creds)
+	if assert.NotNil(t, config.CallCreds) {
+		md, err := config.CallCreds.GetRequestMetadata(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token", md["authorization"])
+	}
+}
+
+func TestNewConfigFromBootstrapContents_TLSMissingCAFile_ShouldFail(t *testing.T) {
+	_, err := NewConfigFromBootstrapContents([]byte(`{"xds_servers": [{"server_uri": "a", "channel_creds": [{"type": "tls", "config": {"ca_certificate_file": "/nonexistent"}}]}]}`))
+
+	assert.Error(t, err)
+}
+
+func TestNewConfigFromBootstrap_FileNameEnv_ShouldTakePrecedence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "bootstrap.json")
+	if err := os.WriteFile(fileName, []byte(testBootstrap), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(BootstrapFileNameEnv, fileName)
+	t.Setenv(BootstrapFileContentEnv, `{}`)
+
+	config, err := NewConfigFromBootstrap()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "istiod.istio-system.svc:15010", config.ServerURI)
+}
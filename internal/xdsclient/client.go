@@ -1,10 +1,17 @@
 package xdsclient
 
 import (
+	"time"
+
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
 )
 
 // ServerConfig contains the configuration to connect to a server, including
@@ -13,19 +20,116 @@ type ServerConfig struct {
 	// ServerURI is the management server to connect to.
 	//
 	// The bootstrap file contains an ordered list of xDS servers to contact for
-	// this authority. The first one is picked.
+	// this authority. The first one is picked, the others are the
+	// FallbackServers.
 	ServerURI string
 	// Creds contains the credentials to be used while talking to the xDS
-	// server, as a grpc.DialOption.
+	// server, as a grpc.DialOption, such as the one of NewTLSCredentials.
 	Creds grpc.DialOption
+	// CallCreds, when set, authenticates every request to the xDS server,
+	// such as with the token of NewTokenFileCredentials.
+	CallCreds credentials.PerRPCCredentials
+	// TransportMode selects the discovery services used to fetch resources.
+	// The zero value multiplexes every resource type over a single ADS stream.
+	TransportMode TransportMode
+	// FallbackServers are the management servers to fail over to, in order,
+	// while ServerURI is unreachable. The client returns to ServerURI once it
+	// is reachable again, and keeps the resources it received meanwhile. Only
+	// their ServerURI, Creds and CallCreds are used.
+	FallbackServers []ServerConfig
+	// Delta selects the incremental variant of the protocol, where only
+	// changed resources are sent and subscriptions are updated with diffs.
+	Delta bool
 
 	NodeId string
+	// Node is the identity sent to the management server, such as the
+	// metadata Istio expects or the locality Traffic Director expects.
+	// NodeId, when set, takes precedence over its id. The user agent and
+	// client features are filled in by the client.
+	Node *corev3.Node
+	// SetNodeOnFirstMessageOnly sends Node only on the first request of each
+	// stream instead of on every request, as Envoy does when the
+	// set_node_on_first_message_only option of its config source is set.
+	SetNodeOnFirstMessageOnly bool
+	// ServerFeatures lists the features supported by the management server,
+	// such as "xds_v3" or "ignore_resource_deletion".
+	ServerFeatures []string
+	// Authorities maps xDS federation authority names to their
+	// configuration. Resources named xdstp://<authority>/<type>/<id> are
+	// fetched from the management server of their authority.
+	Authorities map[string]*Authority
+	// CertProviderConfigs maps certificate provider plugin instance names to
+	// their configuration.
+	CertProviderConfigs map[string]*CertProviderConfig
+	// WatchExpiryTimeout is how long a watched resource may take to arrive
+	// before its watchers are told it does not exist. It defaults to
+	// DefaultWatchExpiryTimeout.
+	WatchExpiryTimeout time.Duration
+	// PersistDir, when set, is the directory where the last accepted
+	// resources of every type are stored. They are loaded when the type is
+	// first watched, so that a client restarted while the management server
+	// is unreachable keeps serving them until fresh ones arrive.
+	PersistDir string
+	// MaxStaleness is how old the stored resources may be to be loaded. Zero
+	// means no limit.
+	MaxStaleness time.Duration
+}
+
+// DefaultWatchExpiryTimeout is the WatchExpiryTimeout used when none is set.
+const DefaultWatchExpiryTimeout = 15 * time.Second
+
+// serverFeatureIgnoreResourceDeletion is the server feature telling clients
+// not to delete Listeners and Clusters missing from state-of-the-world
+// responses.
+const serverFeatureIgnoreResourceDeletion = "ignore_resource_deletion"
+
+func (c ServerConfig) watchExpiryTimeout() time.Duration {
+	if c.WatchExpiryTimeout > 0 {
+		return c.WatchExpiryTimeout
+	}
+	return DefaultWatchExpiryTimeout
+}
+
+func (c ServerConfig) ignoreResourceDeletion() bool {
+	for _, feature := range c.ServerFeatures {
+		if feature == serverFeatureIgnoreResourceDeletion {
+			return true
+		}
+	}
+	return false
 }
 
+// TransportMode selects how resources are requested from the management
+// server.
+type TransportMode int
+
+const (
+	// TransportModeADS multiplexes every resource type over a single
+	// AggregatedDiscoveryService stream, on which the management server can
+	// sequence the updates of the different types. The client does not
+	// enforce any order itself.
+	TransportModeADS TransportMode = iota
+	// TransportModeSeparate opens one stream per resource type on the type
+	// specific discovery services (LDS, RDS, CDS and EDS).
+	TransportModeSeparate
+)
+
 type XDSClient interface {
+	// Producer watches resources of any registered Type. The typed Watch*
+	// methods below are wrappers of it for the built-in types.
+	resourcev3.Producer
+
 	WatchListener(string, func([]*listenerv3.Listener, error)) func()
 	WatchRouteConfig(string, func([]*routev3.RouteConfiguration, error)) func()
 	WatchCluster(string, func([]*clusterv3.Cluster, error)) func()
+	WatchEndpoints(string, func([]*endpointv3.ClusterLoadAssignment, error)) func()
+
+	// DumpResources returns the update metadata and last accepted copy of
+	// every known resource, keyed by type URL and resource name.
+	DumpResources() map[string]map[string]resourcev3.UpdateWithMD
+
+	// Node returns the identity sent to the management server.
+	Node() *corev3.Node
 
 	Close()
 }
@@ -3,230 +3,578 @@ package xdsclient
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
+	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
-	cdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
-	ldsv3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
-	rdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
-	"github.com/golang/protobuf/proto"
-	"github.com/golang/protobuf/ptypes/any"
-	"google.golang.org/grpc"
+	"github.com/rs/zerolog/log"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
 
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
 	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
 )
 
+// New returns a client fetching resources from the management server of
+// config, and the xdstp:// ones from the servers of their authorities.
 func New(config ServerConfig) (XDSClient, error) {
-	conn, err := grpc.Dial(config.ServerURI, config.Creds)
+	c, err := newClient(config)
 	if err != nil {
-		return nil, fmt.Errorf("fail to dial xds server: %w", err)
+		return nil, err
+	}
+	if c.authorities, err = newAuthorities(c, config); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(config ServerConfig) (*clientImpl, error) {
+	servers, err := dialServers(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var store *fileStore
+	if config.PersistDir != "" {
+		store = &fileStore{dir: config.PersistDir, maxStaleness: config.MaxStaleness}
 	}
 
-	return &clientImpl{
-		conn:           conn,
-		serverConfig:   config,
-		done:           &event.Event{},
-		rdsClient:      rdsv3.NewRouteDiscoveryServiceClient(conn),
-		ldsClient:      ldsv3.NewListenerDiscoveryServiceClient(conn),
-		cdsClient:      cdsv3.NewClusterDiscoveryServiceClient(conn),
-		listenersNames: make(map[string]struct{}),
-		clustersNames:  make(map[string]struct{}),
-		routesNames:    make(map[string]struct{}),
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &clientImpl{
+		store:         store,
+		ctx:           ctx,
+		cancel:        cancel,
+		servers:       servers,
+		serverConfig:  config,
+		node:          newNode(config),
+		done:          event.NewEvent(),
+		resourceTypes: newResourceTypeRegistry(),
+		streams:       make(map[string]*stream),
+		watches:       make(map[string]*watchState),
+	}
+	if len(servers) > 1 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.watchPrimary()
+		}()
+	}
+	return c, nil
 }
 
 type clientImpl struct {
-	serverConfig  ServerConfig
-	conn          *grpc.ClientConn
+	serverConfig ServerConfig
+	node         *corev3.Node
+	// servers holds the primary management server followed by the fallback
+	// ones.
+	servers       []*server
 	done          *event.Event
-	resourceTypes resourceTypeRegistry
-	rdsClient     rdsv3.RouteDiscoveryServiceClient
-	ldsClient     ldsv3.ListenerDiscoveryServiceClient
-	cdsClient     cdsv3.ClusterDiscoveryServiceClient
+	resourceTypes *resourceTypeRegistry
+	// store persists the accepted resources, nil if disabled.
+	store *fileStore
+	// authorities holds the client of every federation authority, keyed by
+	// name. Authorities without a management server of their own map to the
+	// client itself. It is nil for the clients of the authorities.
+	authorities map[string]*clientImpl
+	// file feeds the resources instead of a management server when the
+	// client is built by NewFromFile. servers and streams are unused then.
+	file *fileSource
+
+	// ctx scopes every gRPC stream and is cancelled by Close, and wg tracks
+	// the goroutine of every stream so that Close can wait for them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 
-	listenersNames map[string]struct{}
-	clustersNames  map[string]struct{}
-	routesNames    map[string]struct{}
+	mu sync.Mutex
+	// active is the index in servers of the management server the streams
+	// connect to.
+	active int
+	// streams is keyed by type URL. In ADS mode every type shares the stream
+	// stored under the empty key.
+	streams map[string]*stream
+	// watches is keyed by type URL.
+	watches map[string]*watchState
 }
 
-func (c *clientImpl) addListener(resourceName string) {
-	if resourceName == "" {
-		return
+func (c *clientImpl) WatchListener(resourceName string, callback func([]*listenerv3.Listener, error)) func() {
+	return c.WatchResource(resourcev3.ListenerType, resourceName, &callbackWatcher{
+		onUpdate: func(data resourcev3.ResourceData) {
+			callback([]*listenerv3.Listener{data.(*resourcev3.ListenerResourceData).Resource}, nil)
+		},
+		onError: func(err error) { callback(nil, err) },
+	})
+}
+
+func (c *clientImpl) WatchRouteConfig(resourceName string, callback func([]*routev3.RouteConfiguration, error)) func() {
+	return c.WatchResource(resourcev3.RouteConfigType, resourceName, &callbackWatcher{
+		onUpdate: func(data resourcev3.ResourceData) {
+			callback([]*routev3.RouteConfiguration{data.(*resourcev3.RouteConfigResourceData).Resource}, nil)
+		},
+		onError: func(err error) { callback(nil, err) },
+	})
+}
+
+func (c *clientImpl) WatchCluster(resourceName string, callback func([]*clusterv3.Cluster, error)) func() {
+	return c.WatchResource(resourcev3.ClusterType, resourceName, &callbackWatcher{
+		onUpdate: func(data resourcev3.ResourceData) {
+			callback([]*clusterv3.Cluster{data.(*resourcev3.ClusterResourceData).Resource}, nil)
+		},
+		onError: func(err error) { callback(nil, err) },
+	})
+}
+
+func (c *clientImpl) WatchEndpoints(resourceName string, callback func([]*endpointv3.ClusterLoadAssignment, error)) func() {
+	return c.WatchResource(resourcev3.EndpointsType, resourceName, &callbackWatcher{
+		onUpdate: func(data resourcev3.ResourceData) {
+			callback([]*endpointv3.ClusterLoadAssignment{data.(*resourcev3.EndpointsResourceData).Resource}, nil)
+		},
+		onError: func(err error) { callback(nil, err) },
+	})
+}
+
+// WatchResource subscribes to resourceName of the given type, an empty name
+// subscribing to every resource of the type, and sends the updated
+// subscription on the stream carrying that type. xdstp:// names are
+// subscribed to on the management server of their authority. The watcher is
+// told about every update of the resources it watches. The returned func
+// cancels the watch, unsubscribing from resourceName once no other watch
+// needs it.
+func (c *clientImpl) WatchResource(rType resourcev3.Type, resourceName string, watcher resourcev3.ResourceWatcher) func() {
+	client := c
+	if c.file == nil {
+		var err error
+		if client, err = c.clientFor(rType.TypeURL(), resourceName); err != nil {
+			watcher.OnError(err)
+			return func() {}
+		}
+	}
+	return client.watchResource(rType, resourceName, watcher)
+}
+
+func (c *clientImpl) watchResource(rType resourcev3.Type, resourceName string, watcher resourcev3.ResourceWatcher) func() {
+	if rType.TypeURL() == version.V3VirtualHostURL && c.file == nil && !c.serverConfig.Delta {
+		// VHDS has no state-of-the-world variant, a stream carrying it would
+		// fail on every attempt.
+		watcher.OnError(fmt.Errorf("fail to watch virtual host %q: VHDS requires the incremental protocol", resourceName))
+		return func() {}
+	}
+	if err := c.resourceTypes.maybeRegister(rType); err != nil {
+		watcher.OnError(err)
+		return func() {}
+	}
+	typeURL := rType.TypeURL()
+	wt := &watch{name: resourceName, watcher: watcher}
+
+	c.mu.Lock()
+	if c.done.HasFired() {
+		c.mu.Unlock()
+		return func() {}
+	}
+	ws := c.watchStateLocked(typeURL)
+	ws.restore(resourceName)
+	ws.addWatch(wt)
+	cached := ws.cached(resourceName)
+	notExist := false
+	if resourceName != "" {
+		notExist = ws.resources[resourceName].MD.Status == resourcev3.ServiceStatusNotExist
+		ws.startExpiryTimer(resourceName, c.serverConfig.watchExpiryTimeout(), func() {
+			c.expireWatch(typeURL, resourceName)
+		})
+	}
+	var s *stream
+	if c.file == nil {
+		s = c.streamFor(typeURL)
 	}
-	_, exists := c.listenersNames[resourceName]
-	if !exists {
-		c.listenersNames[resourceName] = struct{}{}
+	c.mu.Unlock()
+
+	if s != nil {
+		s.sendSubscription(typeURL)
+	} else {
+		c.file.subscribe(typeURL)
+	}
+	if notExist {
+		watcher.OnResourceDoesNotExist()
+		c.notifyDone([]*watch{wt})
+	}
+	// Resources received before the watch are not sent again by the
+	// management server, hand their last accepted copy to the watcher.
+	if results, err := decodeResources(rType, cached); err == nil && len(results) > 0 {
+		c.notifyUpdates([]*watch{wt}, results)
+		c.notifyDone([]*watch{wt})
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			changed := ws.removeWatch(wt)
+			c.mu.Unlock()
+
+			if changed && s != nil && !c.done.HasFired() {
+				s.sendSubscription(typeURL)
+			}
+		})
 	}
 }
 
-func (c *clientImpl) GetListeners() []string {
-	listeners := make([]string, 0, len(c.listenersNames))
-	for k := range c.listenersNames {
-		listeners = append(listeners, k)
+// watchStateLocked returns the state of typeURL, creating it if needed. It
+// must be called with c.mu held.
+func (c *clientImpl) watchStateLocked(typeURL string) *watchState {
+	ws, ok := c.watches[typeURL]
+	if !ok {
+		ws = newWatchState()
+		ws.persisted = c.loadLocked(typeURL)
+		c.watches[typeURL] = ws
 	}
-	return listeners
+	return ws
 }
 
-func (c *clientImpl) addCluster(resourceName string) {
-	if resourceName == "" {
-		return
+// loadLocked returns the resources of typeURL persisted by a previous run, if
+// any. It must be called with c.mu held.
+func (c *clientImpl) loadLocked(typeURL string) map[string]*resourcev3.UpdateWithMD {
+	if c.store == nil {
+		return nil
+	}
+	resources, err := c.store.load(typeURL, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Str("type", typeURL).Msg("fail to load persisted resources, ignoring them")
+		return nil
 	}
-	_, exists := c.clustersNames[resourceName]
-	if !exists {
-		c.clustersNames[resourceName] = struct{}{}
+	if len(resources) > 0 {
+		log.Info().Str("type", typeURL).Int("count", len(resources)).Msg("persisted resources loaded")
 	}
+	return resources
 }
 
-func (c *clientImpl) GetClusters() []string {
-	clusters := make([]string, 0, len(c.clustersNames))
-	for k := range c.clustersNames {
-		clusters = append(clusters, k)
+// persist stores the resources of typeURL. It is given a copy taken with c.mu
+// held, and does nothing if the store is disabled.
+func (c *clientImpl) persist(typeURL string, resources map[string]resourcev3.UpdateWithMD) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.save(typeURL, resources); err != nil {
+		log.Warn().Err(err).Str("type", typeURL).Msg("fail to persist resources")
 	}
-	return clusters
 }
 
-func (c *clientImpl) addRoute(resourceName string) {
-	if resourceName == "" {
-		return
+// streamFor returns the stream carrying typeURL, starting it if needed. It must
+// be called with c.mu held.
+func (c *clientImpl) streamFor(typeURL string) *stream {
+	key := typeURL
+	if c.serverConfig.TransportMode == TransportModeADS {
+		key = ""
 	}
-	_, exists := c.routesNames[resourceName]
-	if !exists {
-		c.routesNames[resourceName] = struct{}{}
+	if s, ok := c.streams[key]; ok {
+		return s
 	}
+
+	s := &stream{client: c, typeURL: key}
+	c.streams[key] = s
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		s.run()
+	}()
+
+	return s
 }
 
-func (c *clientImpl) GetRoutes() []string {
-	routes := make([]string, 0, len(c.routesNames))
-	for k := range c.routesNames {
-		routes = append(routes, k)
+// newStreamClient opens a gRPC stream carrying typeURL to the management
+// server of index srv, scoped by ctx.
+func (c *clientImpl) newStreamClient(ctx context.Context, srv int, typeURL string) (streamClient, error) {
+	s := c.servers[srv]
+	if c.serverConfig.TransportMode == TransportModeADS {
+		return s.adsClient.StreamAggregatedResources(ctx)
+	}
+
+	switch typeURL {
+	case version.V3ListenerURL:
+		return s.ldsClient.StreamListeners(ctx)
+	case version.V3RouteConfigURL:
+		return s.rdsClient.StreamRoutes(ctx)
+	case version.V3ClusterURL:
+		return s.cdsClient.StreamClusters(ctx)
+	case version.V3EndpointsURL:
+		return s.edsClient.StreamEndpoints(ctx)
+	case version.V3ScopedRouteConfigURL:
+		return s.srdsClient.StreamScopedRoutes(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported resource type %q", typeURL)
 	}
-	return routes
 }
 
-func (c *clientImpl) WatchListener(resourceName string, callback func([]*listenerv3.Listener, error)) func() {
-	c.addListener(resourceName)
-	streamClient, err := c.ldsClient.StreamListeners(context.TODO())
-	if err != nil {
-		panic(fmt.Errorf("failed to stream: %w", err).Error())
+// newRequest builds the discovery request for the current subscription of
+// typeURL, carrying the node identity when withNode is set. A non-nil nackErr
+// turns the request into a NACK of the last response received. It returns nil
+// while nothing of the type was ever requested on the stream and nothing is
+// watched: an empty first list of names would subscribe to every resource.
+// Once requested, an empty list unsubscribes from every resource.
+func (c *clientImpl) newRequest(typeURL string, withNode bool, nackErr error) *xdsv3.DiscoveryRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ws, ok := c.watches[typeURL]
+	if !ok || (ws.empty() && !ws.requested) {
+		return nil
 	}
-	genericCallback := func(resources []*any.Any, err error) {
-		if err != nil {
-			callback(nil, err)
-			return
-		}
-		listeners := make([]*listenerv3.Listener, len(resources))
-		for i := range resources {
-			l := &listenerv3.Listener{}
-			if err := proto.Unmarshal(resources[i].GetValue(), l); err != nil {
-				panic(fmt.Errorf("failed to unmarshal resource: %w", err).Error())
-			}
-			listeners[i] = l
+	ws.requested = true
+	if !ws.empty() {
+		ws.unwatched = nil
+	}
+	req := &xdsv3.DiscoveryRequest{
+		TypeUrl:       typeURL,
+		ResourceNames: ws.resourceNames(),
+		VersionInfo:   ws.version,
+		ResponseNonce: ws.nonce,
+	}
+	if withNode {
+		req.Node = c.node
+	}
+	if nackErr != nil {
+		req.ErrorDetail = &statuspb.Status{
+			Code:    int32(codes.InvalidArgument),
+			Message: nackErr.Error(),
 		}
-		callback(listeners, nil)
 	}
+	return req
+}
+
+// watchList returns a copy of the watches registered for typeURL so their
+// watchers can be invoked without holding c.mu.
+func (c *clientImpl) watchList(typeURL string) []*watch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return c.watchResources(c.GetListeners, streamClient, genericCallback)
+	ws, ok := c.watches[typeURL]
+	if !ok {
+		return nil
+	}
+	return ws.watchList()
 }
 
-func (c *clientImpl) WatchRouteConfig(resourceName string, callback func([]*routev3.RouteConfiguration, error)) func() {
-	streamClient, err := c.rdsClient.StreamRoutes(context.TODO())
+// handleResponse decodes and validates resp. If every resource in it is valid
+// the response is accepted and handed to the watchers, otherwise the returned
+// error explains why it has to be NACKed.
+func (c *clientImpl) handleResponse(resp *xdsv3.DiscoveryResponse) error {
+	typeURL := resp.GetTypeUrl()
+	rType := c.resourceTypes.get(typeURL)
+	results, err := c.decodeResources(typeURL, resp.GetResources())
+	now := time.Now()
+
+	c.mu.Lock()
+	ws := c.watchStateLocked(typeURL)
+	ws.nonce = resp.GetNonce()
 	if err != nil {
-		panic(fmt.Errorf("failed to stream: %w", err).Error())
+		ws.nack(decodedNames(results), resp.GetVersionInfo(), err, now)
+		watches := ws.watchList()
+		c.mu.Unlock()
+
+		c.notifyError(watches, err)
+		return err
 	}
-	genericCallback := func(resources []*any.Any, err error) {
-		if err != nil {
-			callback(nil, err)
-			return
-		}
-		routeConfigs := make([]*routev3.RouteConfiguration, len(resources))
-		for i := range resources {
-			rc := &routev3.RouteConfiguration{}
-			if err := proto.Unmarshal(resources[i].GetValue(), rc); err != nil {
-				panic(fmt.Errorf("failed to unmarshal resource: %w", err).Error())
-			}
-			routeConfigs[i] = rc
+
+	ws.version = resp.GetVersionInfo()
+	ws.persisted = nil
+	ws.clearNacks()
+	received := make(map[string]struct{}, len(results))
+	for _, result := range results {
+		// Resources sent before the management server processed an
+		// unsubscription are not recorded: no watch wants them. Files are
+		// only read once per type, so theirs are kept for later watches.
+		if c.file == nil && !ws.watched(result.Name) {
+			continue
 		}
-		callback(routeConfigs, nil)
+		ws.accept(result.Name, resp.GetVersionInfo(), result.Resource.Raw(), now)
+		received[result.Name] = struct{}{}
+	}
+	var removed []string
+	// Files hold every resource of every type.
+	if (rType.AllResourcesRequiredInSotW() || c.file != nil) && !c.serverConfig.ignoreResourceDeletion() {
+		removed = ws.removeMissing(received, now)
 	}
+	watches := ws.watchList()
+	var toPersist map[string]resourcev3.UpdateWithMD
+	if c.store != nil {
+		toPersist = ws.received()
+	}
+	c.mu.Unlock()
+
+	c.persist(typeURL, toPersist)
 
-	return c.watchResources(c.GetRoutes, streamClient, genericCallback)
+	for _, name := range removed {
+		log.Debug().Str("type", typeURL).Str("name", name).Msg("resource removed")
+	}
+	c.notifyUpdates(watches, results)
+	c.notifyRemoved(watches, removed)
+	c.notifyDone(watches)
+	return nil
 }
 
-func (c *clientImpl) WatchCluster(resourceName string, callback func([]*clusterv3.Cluster, error)) func() {
-	streamClient, err := c.cdsClient.StreamClusters(context.TODO())
-	if err != nil {
-		panic(fmt.Errorf("failed to stream: %w", err).Error())
+// notifyUpdates hands every decoded resource to the watches interested in it.
+func (c *clientImpl) notifyUpdates(watches []*watch, results []*resourcev3.DecodeResult) {
+	if c.done.HasFired() {
+		return
 	}
-	genericCallback := func(resources []*any.Any, err error) {
-		if err != nil {
-			callback(nil, err)
-			return
+	for _, wt := range watches {
+		for _, result := range results {
+			if wt.wants(result.Name) {
+				wt.watcher.OnUpdate(result.Resource)
+			}
 		}
-		clusters := make([]*clusterv3.Cluster, len(resources))
-		for i := range resources {
-			c := &clusterv3.Cluster{}
-			if err := proto.Unmarshal(resources[i].GetValue(), c); err != nil {
-				panic(fmt.Errorf("failed to unmarshal resource: %w", err).Error())
+	}
+}
+
+// notifyRemoved tells the watches of every removed resource that it no longer
+// exists.
+func (c *clientImpl) notifyRemoved(watches []*watch, names []string) {
+	if c.done.HasFired() {
+		return
+	}
+	for _, wt := range watches {
+		for _, name := range names {
+			switch wt.name {
+			case name:
+				wt.watcher.OnResourceDoesNotExist()
+			case "":
+				if w, ok := wt.watcher.(resourcev3.ResourceRemovedWatcher); ok {
+					w.OnResourceRemoved(name)
+				}
 			}
-			clusters[i] = c
 		}
-		callback(clusters, nil)
 	}
+}
 
-	return c.watchResources(c.GetClusters, streamClient, genericCallback)
+// expireWatch reports name as not existing if it is still watched and has not
+// been received.
+func (c *clientImpl) expireWatch(typeURL, name string) {
+	c.mu.Lock()
+	ws := c.watchStateLocked(typeURL)
+	if !ws.expire(name, time.Now()) {
+		c.mu.Unlock()
+		return
+	}
+	watches := ws.watchList()
+	c.mu.Unlock()
 
+	log.Warn().Str("type", typeURL).Str("name", name).Msg("watched resource not received, assuming it does not exist")
+	c.notifyRemoved(watches, []string{name})
+	c.notifyDone(watches)
 }
 
-type streamClient interface {
-	Send(*xdsv3.DiscoveryRequest) error
-	Recv() (*xdsv3.DiscoveryResponse, error)
-	grpc.ClientStream
+// notifyDone tells the watches implementing BatchWatcher that every update and
+// removal of a response has been delivered.
+func (c *clientImpl) notifyDone(watches []*watch) {
+	if c.done.HasFired() {
+		return
+	}
+	for _, wt := range watches {
+		if w, ok := wt.watcher.(resourcev3.BatchWatcher); ok {
+			w.OnBatchDone()
+		}
+	}
 }
 
-func (c *clientImpl) watchResources(getResourceNames func() []string, sc streamClient, callback func([]*any.Any, error)) func() {
-	var cancel chan struct{}
+// notifyError reports err to every watch. The last accepted resources stay in
+// use.
+func (c *clientImpl) notifyError(watches []*watch, err error) {
+	if c.done.HasFired() {
+		return
+	}
+	for _, wt := range watches {
+		wt.watcher.OnError(err)
+	}
+}
 
-	go func() {
-		for {
-			req := &xdsv3.DiscoveryRequest{
-				Node: &corev3.Node{
-					Id: c.serverConfig.NodeId,
-				},
-				ResourceNames: getResourceNames(),
-				VersionInfo:   "2",
-			}
-			sc.Send(req)
-			resp, err := sc.Recv()
-			if err != nil {
-				callback(nil, err)
-				continue
-			}
+// Node returns the identity sent to the management server. It must not be
+// modified.
+func (c *clientImpl) Node() *corev3.Node {
+	return c.node
+}
+
+// DumpResources returns the update metadata and last accepted copy of every
+// known resource, keyed by type URL and resource name. The resources of the
+// federation authorities are keyed by their full xdstp:// name.
+func (c *clientImpl) DumpResources() map[string]map[string]resourcev3.UpdateWithMD {
+	dump := make(map[string]map[string]resourcev3.UpdateWithMD)
+	c.dumpResources(dump)
+	for _, client := range c.authorityClients() {
+		client.dumpResources(dump)
+	}
+	return dump
+}
+
+func (c *clientImpl) dumpResources(dump map[string]map[string]resourcev3.UpdateWithMD) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			callback(resp.GetResources(), nil)
+	for typeURL, ws := range c.watches {
+		resources, ok := dump[typeURL]
+		if !ok {
+			resources = make(map[string]resourcev3.UpdateWithMD, len(ws.resources))
+			dump[typeURL] = resources
+		}
+		for name, r := range ws.resources {
+			resources[name] = *r
 		}
-	}()
-	return func() {
-		cancel <- struct{}{}
 	}
 }
 
-// Close closes the gRPC connection to the management server.
-//
-// TODO: ensure that all underlying transports are closed before this function
-// returns.
+// typeURLs returns the resource types carried by the stream keyed by
+// streamKey, every watched type for the ADS stream.
+func (c *clientImpl) typeURLs(streamKey string) []string {
+	if streamKey != "" {
+		return []string{streamKey}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	typeURLs := make([]string, 0, len(c.watches))
+	for typeURL := range c.watches {
+		typeURLs = append(typeURLs, typeURL)
+	}
+	return typeURLs
+}
+
+// handleStreamError reports err to every watch carried by the broken stream.
+// The last accepted resources stay in use until the stream is recreated.
+func (c *clientImpl) handleStreamError(streamKey string, err error) {
+	for _, typeURL := range c.typeURLs(streamKey) {
+		c.notifyError(c.watchList(typeURL), err)
+	}
+}
+
+// Close stops every watch, tears down the streams and closes the gRPC
+// connections to the management servers, those of the authorities included.
+// It returns once every stream goroutine has exited, no callback is invoked
+// afterwards.
 func (c *clientImpl) Close() {
+	c.mu.Lock()
 	if c.done.HasFired() {
+		c.mu.Unlock()
 		return
 	}
 	c.done.Fire()
+	for _, ws := range c.watches {
+		ws.stopExpiryTimers()
+	}
+	c.mu.Unlock()
+
+	c.cancel()
+	c.wg.Wait()
+	closeServers(c.servers)
+	for _, client := range c.authorityClients() {
+		client.Close()
+	}
 
-	log.Printf("Shutdown")
+	log.Debug().Msg("Shutdown")
 }
 
 // A registry of xdsresource.Type implementations indexed by their corresponding
@@ -259,3 +607,20 @@ func (r *resourceTypeRegistry) maybeRegister(rType resourcev3.Type) error {
 	r.types[url] = rType
 	return nil
 }
+
+// callbackWatcher adapts the callbacks of the typed Watch* methods to a
+// ResourceWatcher.
+type callbackWatcher struct {
+	onUpdate func(resourcev3.ResourceData)
+	onError  func(error)
+}
+
+func (w *callbackWatcher) OnUpdate(data resourcev3.ResourceData) {
+	w.onUpdate(data)
+}
+
+func (w *callbackWatcher) OnError(err error) {
+	w.onError(err)
+}
+
+func (w *callbackWatcher) OnResourceDoesNotExist() {}
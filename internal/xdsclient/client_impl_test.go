@@ -0,0 +1,296 @@
+package xdsclient
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
+)
+
+// recordingWatcher records the events it receives.
+type recordingWatcher struct {
+	mu       sync.Mutex
+	updates  []string
+	removed  []string
+	errors   []error
+	notExist int
+}
+
+func (w *recordingWatcher) OnUpdate(data resourcev3.ResourceData) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch data := data.(type) {
+	case *resourcev3.ClusterResourceData:
+		w.updates = append(w.updates, data.Resource.GetName())
+	case *resourcev3.VirtualHostResourceData:
+		w.updates = append(w.updates, data.Resource.GetName())
+	}
+}
+
+func (w *recordingWatcher) OnError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errors = append(w.errors, err)
+}
+
+func (w *recordingWatcher) OnResourceDoesNotExist() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.notExist++
+}
+
+func (w *recordingWatcher) OnResourceRemoved(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.removed = append(w.removed, name)
+}
+
+// newTestClient returns a client without connection whose watches are added
+// directly to its state.
+func newTestClient(config ServerConfig, watches ...*watch) *clientImpl {
+	c := &clientImpl{
+		serverConfig:  config,
+		done:          event.NewEvent(),
+		resourceTypes: newResourceTypeRegistry(),
+		streams:       make(map[string]*stream),
+		watches:       make(map[string]*watchState),
+	}
+	_ = c.resourceTypes.maybeRegister(resourcev3.ClusterType)
+	ws := c.watchStateLocked(version.V3ClusterURL)
+	for _, wt := range watches {
+		ws.addWatch(wt)
+	}
+	return c
+}
+
+func clusterResponse(t *testing.T, versionInfo string, names ...string) *xdsv3.DiscoveryResponse {
+	resources := make([]*any.Any, len(names))
+	for i, name := range names {
+		raw, err := anypb.New(&clusterv3.Cluster{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources[i] = raw
+	}
+	return &xdsv3.DiscoveryResponse{TypeUrl: version.V3ClusterURL, VersionInfo: versionInfo, Resources: resources}
+}
+
+func TestHandleResponse_SotWDropsCluster_ShouldReportRemoval(t *testing.T) {
+	wildcard, named := &recordingWatcher{}, &recordingWatcher{}
+	c := newTestClient(ServerConfig{}, &watch{watcher: wildcard}, &watch{name: "cluster_1", watcher: named})
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0", "cluster_1")))
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "2", "cluster_0")))
+
+	assert.Equal(t, []string{"cluster_0", "cluster_1", "cluster_0"}, wildcard.updates)
+	assert.Equal(t, []string{"cluster_1"}, wildcard.removed)
+	assert.Equal(t, []string{"cluster_1"}, named.updates)
+	assert.Equal(t, 1, named.notExist)
+	assert.Equal(t, resourcev3.ServiceStatusNotExist, c.DumpResources()[version.V3ClusterURL]["cluster_1"].MD.Status)
+}
+
+func TestHandleResponse_UnwatchedCluster_ShouldBeIgnored(t *testing.T) {
+	named := &recordingWatcher{}
+	c := newTestClient(ServerConfig{}, &watch{name: "cluster_0", watcher: named})
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0", "cluster_1")))
+
+	assert.Equal(t, []string{"cluster_0"}, named.updates)
+	assert.Contains(t, c.DumpResources()[version.V3ClusterURL], "cluster_0")
+	assert.NotContains(t, c.DumpResources()[version.V3ClusterURL], "cluster_1", "resources nobody watches should not be recorded as ACKed")
+}
+
+func TestHandleResponse_SotWDropsScope_ShouldReportRemoval(t *testing.T) {
+	c := newTestClient(ServerConfig{})
+	_ = c.resourceTypes.maybeRegister(resourcev3.ScopedRouteConfigType)
+	w := &recordingWatcher{}
+	c.watchStateLocked(version.V3ScopedRouteConfigURL).addWatch(&watch{watcher: w})
+	scope := func(name string) *any.Any {
+		raw, _ := anypb.New(&routev3.ScopedRouteConfiguration{
+			Name:                   name,
+			RouteConfigurationName: "route_config_0",
+			Key: &routev3.ScopedRouteConfiguration_Key{
+				Fragments: []*routev3.ScopedRouteConfiguration_Key_Fragment{{
+					Type: &routev3.ScopedRouteConfiguration_Key_Fragment_StringKey{StringKey: name},
+				}},
+			},
+		})
+		return raw
+	}
+
+	assert.NoError(t, c.handleResponse(&xdsv3.DiscoveryResponse{
+		TypeUrl:     version.V3ScopedRouteConfigURL,
+		VersionInfo: "1",
+		Resources:   []*any.Any{scope("scope_0"), scope("scope_1")},
+	}))
+	assert.NoError(t, c.handleResponse(&xdsv3.DiscoveryResponse{
+		TypeUrl:     version.V3ScopedRouteConfigURL,
+		VersionInfo: "2",
+		Resources:   []*any.Any{scope("scope_0")},
+	}))
+
+	assert.Equal(t, []string{"scope_1"}, w.removed, "scopes left out of a response should be removed")
+}
+
+func TestHandleResponse_IgnoreResourceDeletion_ShouldKeepCluster(t *testing.T) {
+	w := &recordingWatcher{}
+	c := newTestClient(ServerConfig{ServerFeatures: []string{"ignore_resource_deletion"}}, &watch{watcher: w})
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0", "cluster_1")))
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "2", "cluster_0")))
+
+	assert.Empty(t, w.removed)
+	assert.Equal(t, resourcev3.ServiceStatusACKed, c.DumpResources()[version.V3ClusterURL]["cluster_1"].MD.Status)
+}
+
+func TestExpireWatch_ResourceNeverSent_ShouldReportDoesNotExist(t *testing.T) {
+	w := &recordingWatcher{}
+	c := newTestClient(ServerConfig{}, &watch{name: "cluster_0", watcher: w})
+	c.mu.Lock()
+	c.watches[version.V3ClusterURL].startExpiryTimer("cluster_0", time.Millisecond, func() {
+		c.expireWatch(version.V3ClusterURL, "cluster_0")
+	})
+	c.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.notExist == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, resourcev3.ServiceStatusNotExist, c.DumpResources()[version.V3ClusterURL]["cluster_0"].MD.Status)
+}
+
+func TestExpireWatch_ResourceReceived_ShouldNotExpire(t *testing.T) {
+	w := &recordingWatcher{}
+	c := newTestClient(ServerConfig{}, &watch{name: "cluster_0", watcher: w})
+	c.watches[version.V3ClusterURL].startExpiryTimer("cluster_0", time.Hour, func() {})
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0")))
+	c.expireWatch(version.V3ClusterURL, "cluster_0")
+
+	assert.Zero(t, w.notExist)
+	assert.Empty(t, c.watches[version.V3ClusterURL].expiryTimers)
+}
+
+func TestHandleResponse_RejectedCluster_ShouldNackOnlyResponseResources(t *testing.T) {
+	c := newTestClient(ServerConfig{}, &watch{watcher: &recordingWatcher{}})
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0", "cluster_1")))
+
+	invalid, _ := anypb.New(&clusterv3.Cluster{Name: "cluster_1", ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS}})
+	assert.Error(t, c.handleResponse(&xdsv3.DiscoveryResponse{TypeUrl: version.V3ClusterURL, VersionInfo: "2", Resources: []*any.Any{invalid}}))
+
+	dump := c.DumpResources()[version.V3ClusterURL]
+	assert.Equal(t, resourcev3.ServiceStatusACKed, dump["cluster_0"].MD.Status)
+	assert.Nil(t, dump["cluster_0"].MD.ErrState)
+	assert.Equal(t, resourcev3.ServiceStatusNACKed, dump["cluster_1"].MD.Status)
+	assert.Equal(t, "1", dump["cluster_1"].MD.Version)
+	if assert.NotNil(t, dump["cluster_1"].MD.ErrState) {
+		assert.Equal(t, "2", dump["cluster_1"].MD.ErrState.Version)
+	}
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "3", "cluster_0", "cluster_1")))
+
+	dump = c.DumpResources()[version.V3ClusterURL]
+	assert.Equal(t, resourcev3.ServiceStatusACKed, dump["cluster_1"].MD.Status)
+	assert.Nil(t, dump["cluster_1"].MD.ErrState)
+}
+
+func TestHandleDeltaResponse_RejectedCluster_ShouldNackOnlyResponseResources(t *testing.T) {
+	c := newTestClient(ServerConfig{Delta: true}, &watch{watcher: &recordingWatcher{}})
+	valid := func(name, resourceVersion string) *xdsv3.Resource {
+		raw, _ := anypb.New(&clusterv3.Cluster{Name: name})
+		return &xdsv3.Resource{Name: name, Version: resourceVersion, Resource: raw}
+	}
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl:   version.V3ClusterURL,
+		Resources: []*xdsv3.Resource{valid("cluster_0", "1"), valid("cluster_1", "1")},
+	}))
+
+	invalid, _ := anypb.New(&clusterv3.Cluster{Name: "cluster_1", ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS}})
+	assert.Error(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl:   version.V3ClusterURL,
+		Resources: []*xdsv3.Resource{{Name: "cluster_1", Version: "2", Resource: invalid}},
+	}))
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl:   version.V3ClusterURL,
+		Resources: []*xdsv3.Resource{valid("cluster_0", "3")},
+	}))
+
+	dump := c.DumpResources()[version.V3ClusterURL]
+	assert.Equal(t, resourcev3.ServiceStatusACKed, dump["cluster_0"].MD.Status, "resources left out of a rejected response should stay ACKed")
+	assert.Equal(t, resourcev3.ServiceStatusNACKed, dump["cluster_1"].MD.Status)
+
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl:   version.V3ClusterURL,
+		Resources: []*xdsv3.Resource{valid("cluster_1", "4")},
+	}))
+
+	dump = c.DumpResources()[version.V3ClusterURL]
+	assert.Equal(t, resourcev3.ServiceStatusACKed, dump["cluster_1"].MD.Status)
+	assert.Equal(t, "4", dump["cluster_1"].MD.Version)
+	assert.Nil(t, dump["cluster_1"].MD.ErrState)
+}
+
+func TestWatchResource_VirtualHostOverSotW_ShouldReportError(t *testing.T) {
+	c := newTestClient(ServerConfig{})
+	w := &recordingWatcher{}
+
+	c.WatchResource(resourcev3.VirtualHostType, "route_config_0/host_0", w)
+
+	assert.Len(t, w.errors, 1)
+	assert.Empty(t, c.streams, "no stream should be opened for VHDS over the state-of-the-world protocol")
+}
+
+func TestHandleDeltaResponse_AliasedVirtualHost_ShouldNotifyAliasWatch(t *testing.T) {
+	c := newTestClient(ServerConfig{Delta: true})
+	_ = c.resourceTypes.maybeRegister(resourcev3.VirtualHostType)
+	w := &recordingWatcher{}
+	c.watchStateLocked(version.V3VirtualHostURL).addWatch(&watch{name: "route_config_0/host_0", watcher: w})
+	raw, _ := anypb.New(&routev3.VirtualHost{Name: "vh_0", Domains: []string{"host_0"}})
+
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl: version.V3VirtualHostURL,
+		Resources: []*xdsv3.Resource{{
+			Name:     "route_config_0/vh_0",
+			Aliases:  []string{"route_config_0/host_0"},
+			Version:  "1",
+			Resource: raw,
+		}},
+	}))
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl:          version.V3VirtualHostURL,
+		RemovedResources: []string{"route_config_0/vh_0"},
+	}))
+
+	assert.Equal(t, []string{"vh_0"}, w.updates, "the virtual host should be delivered under its alias")
+	assert.Equal(t, 1, w.notExist, "removing the resource should remove its alias")
+}
+
+func TestHandleDeltaResponse_VirtualHostNotFound_ShouldReportDoesNotExist(t *testing.T) {
+	c := newTestClient(ServerConfig{Delta: true})
+	_ = c.resourceTypes.maybeRegister(resourcev3.VirtualHostType)
+	w := &recordingWatcher{}
+	c.watchStateLocked(version.V3VirtualHostURL).addWatch(&watch{name: "route_config_0/host_0", watcher: w})
+
+	assert.NoError(t, c.handleDeltaResponse(&xdsv3.DeltaDiscoveryResponse{
+		TypeUrl: version.V3VirtualHostURL,
+		Resources: []*xdsv3.Resource{{
+			Name:    "route_config_0/host_0",
+			Aliases: []string{"route_config_0/host_0"},
+		}},
+	}))
+
+	assert.Empty(t, w.errors, "a resource without body should not be NACKed")
+	assert.Equal(t, 1, w.notExist)
+	assert.Equal(t, resourcev3.ServiceStatusNotExist, c.DumpResources()[version.V3VirtualHostURL]["route_config_0/host_0"].MD.Status)
+}
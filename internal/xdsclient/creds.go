@@ -0,0 +1,209 @@
+package xdsclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// fileTLSCredentials are TLS transport credentials read from files: the CA
+// certificates verifying the management server and, for mTLS, the
+// certificate and key presented to it. The files are read again on the next
+// handshake once one of them changed, so that rotated certificates are used
+// without restarting the client.
+type fileTLSCredentials struct {
+	caFile, certFile, keyFile string
+	serverName                string
+
+	mu sync.Mutex
+	// modTimes are those of the files when config was loaded.
+	modTimes []time.Time
+	config   *tls.Config
+}
+
+// NewTLSCredentials returns transport credentials verifying the management
+// server with the CA certificates of caFile, or the system ones if caFile is
+// empty, and presenting the certificate of certFile and keyFile if set. It
+// fails if the files cannot be loaded.
+func NewTLSCredentials(caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("certificate and private key files must be set together")
+	}
+	c := &fileTLSCredentials{caFile: caFile, certFile: certFile, keyFile: keyFile}
+	if _, err := c.tlsConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// tlsConfig returns the config loaded from the files, loading them again if
+// they changed. The last config is kept if they cannot be loaded.
+func (c *fileTLSCredentials) tlsConfig() (*tls.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTimes, err := fileModTimes(c.caFile, c.certFile, c.keyFile)
+	if err == nil && c.config != nil && sameTimes(modTimes, c.modTimes) {
+		return c.config, nil
+	}
+	var config *tls.Config
+	if err == nil {
+		config, err = c.load()
+	}
+	if err != nil {
+		if c.config == nil {
+			return nil, err
+		}
+		log.Warn().Err(err).Msg("fail to reload xds TLS credentials, using the last loaded ones")
+		return c.config, nil
+	}
+
+	c.config, c.modTimes = config, modTimes
+	return config, nil
+}
+
+func (c *fileTLSCredentials) load() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.serverName}
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read CA certificate file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificate found in %s", c.caFile)
+		}
+	}
+	if c.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (c *fileTLSCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(config).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *fileTLSCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("xds TLS credentials are client side only")
+}
+
+func (c *fileTLSCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2", ServerName: c.serverName}
+}
+
+func (c *fileTLSCredentials) Clone() credentials.TransportCredentials {
+	return &fileTLSCredentials{caFile: c.caFile, certFile: c.certFile, keyFile: c.keyFile, serverName: c.serverName}
+}
+
+func (c *fileTLSCredentials) OverrideServerName(serverName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serverName = serverName
+	c.config = nil
+	return nil
+}
+
+// tokenFileCredentials send the token held in a file as a bearer token with
+// every request, such as the JWT Istio mounts in workloads. The file is read
+// again once it changed.
+type tokenFileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+// NewTokenFileCredentials returns call credentials sending the token held in
+// the file at path as a bearer token. They require a secure connection.
+func NewTokenFileCredentials(path string) credentials.PerRPCCredentials {
+	return &tokenFileCredentials{path: path}
+}
+
+func (c *tokenFileCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := c.currentToken()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (c *tokenFileCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// currentToken returns the token of the file, reading it again if it
+// changed. The last token is kept if the file cannot be read.
+func (c *tokenFileCredentials) currentToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err == nil && c.token != "" && info.ModTime().Equal(c.modTime) {
+		return c.token, nil
+	}
+	var b []byte
+	if err == nil {
+		b, err = os.ReadFile(c.path)
+	}
+	if err == nil && len(strings.TrimSpace(string(b))) == 0 {
+		err = fmt.Errorf("token file %s is empty", c.path)
+	}
+	if err != nil {
+		if c.token == "" {
+			return "", fmt.Errorf("fail to read token file: %w", err)
+		}
+		log.Warn().Err(err).Msg("fail to reload xds token file, using the last read token")
+		return c.token, nil
+	}
+
+	c.token, c.modTime = strings.TrimSpace(string(b)), info.ModTime()
+	return c.token, nil
+}
+
+// fileModTimes returns the modification times of the files at the non-empty
+// paths.
+func fileModTimes(paths ...string) ([]time.Time, error) {
+	modTimes := make([]time.Time, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
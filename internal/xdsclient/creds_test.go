@@ -0,0 +1,174 @@
+package xdsclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues the certificates of the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of a leaf certificate
+// for localhost with the given serial number.
+func (ca *testCA) issue(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects creds to a TLS server requiring client certificates, and
+// returns the serial number of the client certificate the server received.
+func handshake(t *testing.T, creds credentials.TransportCredentials, server tls.Certificate, ca *testCA) int64 {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	serials := make(chan int64, 1)
+	go func() {
+		defer serverConn.Close()
+		conn := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{server},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			NextProtos:   []string{"h2"},
+		})
+		if err := conn.Handshake(); err != nil {
+			serials <- 0
+			return
+		}
+		serials <- conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}()
+
+	_, _, err := creds.ClientHandshake(context.Background(), "localhost", clientConn)
+	assert.NoError(t, err)
+	return <-serials
+}
+
+func TestTLSCredentials_CertRotated_ShouldPresentNewCert(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	serverCert, serverKey := ca.issue(t, 100)
+	server, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	writeFile(t, caFile, ca.pem, now)
+	cert, key := ca.issue(t, 1)
+	writeFile(t, certFile, cert, now)
+	writeFile(t, keyFile, key, now)
+
+	creds, err := NewTLSCredentials(caFile, certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), handshake(t, creds, server, ca))
+
+	cert, key = ca.issue(t, 2)
+	writeFile(t, certFile, cert, now.Add(time.Second))
+	writeFile(t, keyFile, key, now.Add(time.Second))
+	assert.Equal(t, int64(2), handshake(t, creds, server, ca), "rotated certificate should be used")
+
+	writeFile(t, keyFile, []byte("garbage"), now.Add(2*time.Second))
+	assert.Equal(t, int64(2), handshake(t, creds, server, ca), "last valid certificate should be kept")
+}
+
+func TestTLSCredentials_MissingFile_ShouldFail(t *testing.T) {
+	_, err := NewTLSCredentials(filepath.Join(t.TempDir(), "ca.pem"), "", "")
+
+	assert.Error(t, err)
+}
+
+func TestTokenFileCredentials_TokenRotated_ShouldSendNewToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	now := time.Now()
+	writeFile(t, path, []byte("token-1\n"), now)
+	creds := NewTokenFileCredentials(path)
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-1", md["authorization"])
+
+	writeFile(t, path, []byte("token-2\n"), now.Add(time.Second))
+	md, err = creds.GetRequestMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-2", md["authorization"])
+
+	assert.NoError(t, os.Remove(path))
+	md, err = creds.GetRequestMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-2", md["authorization"], "last token should be kept")
+}
@@ -0,0 +1,48 @@
+package xdsclient
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes/any"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// decodeResources decodes and validates every resource of a response with
+// rType. The whole response is NACKed if any resource is invalid, and the
+// error reports the first one. The results are in the order of resources and
+// still returned along with the error: an invalid resource has no Resource,
+// and is nil when its name could not be read either.
+func decodeResources(rType resourcev3.Type, resources []*any.Any) ([]*resourcev3.DecodeResult, error) {
+	results := make([]*resourcev3.DecodeResult, len(resources))
+	var firstErr error
+	for i, r := range resources {
+		result, err := rType.Decode(r)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("resource %d: %w", i, err)
+		}
+		results[i] = result
+	}
+	return results, firstErr
+}
+
+// decodedNames returns the names of the decoded results, valid or not.
+func decodedNames(results []*resourcev3.DecodeResult) []string {
+	var names []string
+	for _, result := range results {
+		if result != nil && result.Name != "" {
+			names = append(names, result.Name)
+		}
+	}
+	return names
+}
+
+// decodeResources looks up the resource type registered for typeURL and
+// decodes resources with it.
+func (c *clientImpl) decodeResources(typeURL string, resources []*any.Any) ([]*resourcev3.DecodeResult, error) {
+	rType := c.resourceTypes.get(typeURL)
+	if rType == nil {
+		return nil, fmt.Errorf("unsupported resource type %q", typeURL)
+	}
+	return decodeResources(rType, resources)
+}
@@ -0,0 +1,67 @@
+package xdsclient
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+func TestDecodeResources_ValidListener_ShouldDecode(t *testing.T) {
+	raw, _ := anypb.New(&listenerv3.Listener{Name: "listener_0"})
+
+	results, err := decodeResources(resourcev3.ListenerType, []*any.Any{raw})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "listener_0", results[0].Name)
+}
+
+func TestDecodeResources_TypeMismatch_ShouldFail(t *testing.T) {
+	raw, _ := anypb.New(&clusterv3.Cluster{Name: "cluster_0"})
+
+	_, err := decodeResources(resourcev3.ListenerType, []*any.Any{raw})
+
+	assert.Error(t, err)
+}
+
+func TestDecodeResources_CorruptedResource_ShouldFail(t *testing.T) {
+	raw := &any.Any{TypeUrl: version.V3ListenerURL, Value: []byte{0xff}}
+
+	_, err := decodeResources(resourcev3.ListenerType, []*any.Any{raw})
+
+	assert.Error(t, err)
+}
+
+func TestDecodeResources_UnnamedResource_ShouldFail(t *testing.T) {
+	raw, _ := anypb.New(&listenerv3.Listener{})
+
+	_, err := decodeResources(resourcev3.ListenerType, []*any.Any{raw})
+
+	assert.Error(t, err)
+}
+
+func TestDecodeResources_UnregisteredType_ShouldFail(t *testing.T) {
+	c := &clientImpl{resourceTypes: newResourceTypeRegistry()}
+	raw, _ := anypb.New(&listenerv3.Listener{Name: "listener_0"})
+
+	_, err := c.decodeResources(version.V3ListenerURL, []*any.Any{raw})
+
+	assert.Error(t, err)
+}
+
+func TestDecodeResources_InvalidResource_ShouldKeepItsName(t *testing.T) {
+	valid, _ := anypb.New(&clusterv3.Cluster{Name: "cluster_0"})
+	invalid, _ := anypb.New(&clusterv3.Cluster{Name: "cluster_1", ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS}})
+
+	results, err := decodeResources(resourcev3.ClusterType, []*any.Any{invalid, valid})
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"cluster_1", "cluster_0"}, decodedNames(results))
+	assert.Nil(t, results[0].Resource)
+}
@@ -0,0 +1,201 @@
+package xdsclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/rs/zerolog/log"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+type deltaStreamClient interface {
+	Send(*xdsv3.DeltaDiscoveryRequest) error
+	Recv() (*xdsv3.DeltaDiscoveryResponse, error)
+	grpc.ClientStream
+}
+
+func (c *clientImpl) newDeltaStreamClient(ctx context.Context, srv int, typeURL string) (deltaStreamClient, error) {
+	s := c.servers[srv]
+	if c.serverConfig.TransportMode == TransportModeADS {
+		return s.adsClient.DeltaAggregatedResources(ctx)
+	}
+
+	switch typeURL {
+	case version.V3ListenerURL:
+		return s.ldsClient.DeltaListeners(ctx)
+	case version.V3RouteConfigURL:
+		return s.rdsClient.DeltaRoutes(ctx)
+	case version.V3ClusterURL:
+		return s.cdsClient.DeltaClusters(ctx)
+	case version.V3EndpointsURL:
+		return s.edsClient.DeltaEndpoints(ctx)
+	case version.V3ScopedRouteConfigURL:
+		return s.srdsClient.DeltaScopedRoutes(ctx)
+	case version.V3VirtualHostURL:
+		return s.vhdsClient.DeltaVirtualHosts(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported resource type %q", typeURL)
+	}
+}
+
+// newDeltaRequest builds an incremental request carrying the subscription
+// changes of typeURL since the last request, carrying the node identity when
+// withNode is set. A non-empty nonce turns it into
+// an ACK of that response, or a NACK when nackErr is not nil. It returns nil
+// when there is nothing to tell the management server.
+func (c *clientImpl) newDeltaRequest(typeURL, nonce string, withNode bool, nackErr error) *xdsv3.DeltaDiscoveryRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ws := c.watchStateLocked(typeURL)
+	first := ws.subscribed == nil
+	if first && ws.empty() {
+		// An empty first request would subscribe to every resource.
+		return nil
+	}
+	subscribe, unsubscribe := ws.subscriptionDiff()
+	if !first && nonce == "" && len(subscribe) == 0 && len(unsubscribe) == 0 {
+		return nil
+	}
+
+	ws.unwatched = nil
+	req := &xdsv3.DeltaDiscoveryRequest{
+		TypeUrl:                  typeURL,
+		ResourceNamesSubscribe:   subscribe,
+		ResourceNamesUnsubscribe: unsubscribe,
+		ResponseNonce:            nonce,
+	}
+	if withNode {
+		req.Node = c.node
+	}
+	if first {
+		req.InitialResourceVersions = ws.resourceVersions()
+	}
+	if nackErr != nil {
+		req.ErrorDetail = &statuspb.Status{
+			Code:    int32(codes.InvalidArgument),
+			Message: nackErr.Error(),
+		}
+	}
+	return req
+}
+
+// resetStreamState forgets the protocol state of typeURL that was scoped to
+// the previous gRPC stream: its nonces mean nothing to the new one, and the
+// next request subscribes to every name again.
+func (c *clientImpl) resetStreamState(typeURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ws := c.watchStateLocked(typeURL)
+	ws.nonce = ""
+	ws.subscribed = nil
+	ws.requested = false
+}
+
+// deltaResourceNames returns the names of resources, their aliases included.
+// The name of the Resource wrapper takes precedence over the decoded one.
+func deltaResourceNames(resources []*xdsv3.Resource, results []*resourcev3.DecodeResult) []string {
+	names := make([]string, 0, len(results))
+	for i, r := range resources {
+		switch {
+		case r.GetName() != "":
+			names = append(names, r.GetName())
+		case results[i] != nil && results[i].Name != "":
+			names = append(names, results[i].Name)
+		}
+		names = append(names, r.GetAliases()...)
+	}
+	return names
+}
+
+// handleDeltaResponse is the incremental counterpart of handleResponse. Only
+// the resources in resp changed, the ones listed in removed_resources are
+// gone and every other resource is left untouched. A Resource without body
+// answers an on-demand lookup of a resource the management server does not
+// have: it does not exist under its name nor any of its aliases.
+func (c *clientImpl) handleDeltaResponse(resp *xdsv3.DeltaDiscoveryResponse) error {
+	typeURL := resp.GetTypeUrl()
+	var resources, notFound []*xdsv3.Resource
+	var raws []*any.Any
+	for _, r := range resp.GetResources() {
+		if r.GetResource() == nil {
+			notFound = append(notFound, r)
+			continue
+		}
+		resources = append(resources, r)
+		raws = append(raws, r.GetResource())
+	}
+	results, err := c.decodeResources(typeURL, raws)
+	now := time.Now()
+
+	c.mu.Lock()
+	ws := c.watchStateLocked(typeURL)
+	ws.nonce = resp.GetNonce()
+	if err != nil {
+		ws.nack(deltaResourceNames(resources, results), resp.GetSystemVersionInfo(), err, now)
+		watches := ws.watchList()
+		c.mu.Unlock()
+
+		c.notifyError(watches, err)
+		return err
+	}
+
+	ws.version = resp.GetSystemVersionInfo()
+	ws.persisted = nil
+	// Resources are named by their Resource wrapper rather than by their
+	// content, and delivered under their aliases as well: the names they
+	// were subscribed to under, such as the "<route configuration>/<host>"
+	// of an on-demand virtual host.
+	var updates []*resourcev3.DecodeResult
+	for i, r := range resources {
+		result := results[i]
+		if r.GetName() != "" {
+			result.Name = r.GetName()
+		}
+		updates = append(updates, result)
+		for _, alias := range ws.acceptAliased(result.Name, r.GetAliases(), r.GetVersion(), result.Resource.Raw(), now) {
+			updates = append(updates, &resourcev3.DecodeResult{Name: alias, Resource: result.Resource})
+		}
+	}
+	var removed []string
+	for _, name := range resp.GetRemovedResources() {
+		log.Debug().Str("type", typeURL).Str("name", name).Msg("resource removed")
+		removed = append(removed, ws.removeAliased(name, now)...)
+	}
+	for _, r := range notFound {
+		log.Debug().Str("type", typeURL).Str("name", r.GetName()).Msg("resource not found")
+		var names []string
+		if r.GetName() != "" {
+			names = ws.removeAliased(r.GetName(), now)
+		}
+		for _, alias := range r.GetAliases() {
+			if !contains(names, alias) {
+				ws.remove(alias, now)
+				names = append(names, alias)
+			}
+		}
+		removed = append(removed, names...)
+	}
+	watches := ws.watchList()
+	var toPersist map[string]resourcev3.UpdateWithMD
+	if c.store != nil {
+		toPersist = ws.received()
+	}
+	c.mu.Unlock()
+
+	c.persist(typeURL, toPersist)
+
+	c.notifyUpdates(watches, updates)
+	c.notifyRemoved(watches, removed)
+	c.notifyDone(watches)
+	return nil
+}
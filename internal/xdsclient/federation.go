@@ -0,0 +1,99 @@
+package xdsclient
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// xdstpScheme is the scheme of xDS federation resource names, of the form
+// xdstp://<authority>/<resource type>/<id>.
+const xdstpScheme = "xdstp"
+
+// parseAuthority returns the authority of resourceName if it is an xdstp://
+// name. It fails if the resource type in the name is not the one of typeURL.
+func parseAuthority(typeURL, resourceName string) (authority string, federated bool, err error) {
+	if !strings.HasPrefix(resourceName, xdstpScheme+"://") {
+		return "", false, nil
+	}
+	u, err := url.Parse(resourceName)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid xdstp resource name %q: %w", resourceName, err)
+	}
+	typeName := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	if !strings.HasPrefix(u.Path, "/"+typeName+"/") {
+		return "", false, fmt.Errorf("xdstp resource name %q is not of type %s", resourceName, typeName)
+	}
+	return u.Host, true, nil
+}
+
+// newAuthorities returns the clients of the authorities of config that have
+// a management server of their own, keyed by authority name. The others use
+// parent. Every client is closed if one fails.
+func newAuthorities(parent *clientImpl, config ServerConfig) (map[string]*clientImpl, error) {
+	authorities := make(map[string]*clientImpl, len(config.Authorities))
+	for name, a := range config.Authorities {
+		if a.XDSServer == nil {
+			authorities[name] = parent
+			continue
+		}
+		child, err := newClient(authorityConfig(config, name, a))
+		if err != nil {
+			for _, c := range authorities {
+				if c != parent {
+					c.Close()
+				}
+			}
+			return nil, fmt.Errorf("authority %q: %w", name, err)
+		}
+		authorities[name] = child
+	}
+	return authorities, nil
+}
+
+// authorityConfig returns the configuration of the client of authority a. The
+// server settings come from its XDSServer, the others from the top-level
+// config. Persisted resources are stored in a subdirectory of their own.
+func authorityConfig(config ServerConfig, name string, a *Authority) ServerConfig {
+	server := *a.XDSServer
+	server.TransportMode = config.TransportMode
+	server.Delta = config.Delta
+	server.NodeId = config.NodeId
+	server.Node = config.Node
+	server.SetNodeOnFirstMessageOnly = config.SetNodeOnFirstMessageOnly
+	server.WatchExpiryTimeout = config.WatchExpiryTimeout
+	server.PersistDir = ""
+	if config.PersistDir != "" {
+		server.PersistDir = filepath.Join(config.PersistDir, "authorities", url.PathEscape(name))
+	}
+	server.MaxStaleness = config.MaxStaleness
+	server.Authorities = nil
+	return server
+}
+
+// clientFor returns the client serving resourceName: the client of its
+// authority for xdstp:// names, c otherwise.
+func (c *clientImpl) clientFor(typeURL, resourceName string) (*clientImpl, error) {
+	authority, federated, err := parseAuthority(typeURL, resourceName)
+	if err != nil || !federated {
+		return c, err
+	}
+	client, ok := c.authorities[authority]
+	if !ok {
+		return nil, fmt.Errorf("xdstp resource name %q has unknown authority %q", resourceName, authority)
+	}
+	return client, nil
+}
+
+// authorityClients returns the clients of the authorities with a management
+// server of their own.
+func (c *clientImpl) authorityClients() []*clientImpl {
+	var clients []*clientImpl
+	for _, client := range c.authorities {
+		if client != c {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
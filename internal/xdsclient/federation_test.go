@@ -0,0 +1,63 @@
+package xdsclient
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+func TestParseAuthority_XdstpName_ShouldReturnAuthority(t *testing.T) {
+	authority, federated, err := parseAuthority(version.V3ClusterURL, "xdstp://eu/envoy.config.cluster.v3.Cluster/cluster_0")
+
+	assert.NoError(t, err)
+	assert.True(t, federated)
+	assert.Equal(t, "eu", authority)
+}
+
+func TestParseAuthority_PlainName_ShouldNotBeFederated(t *testing.T) {
+	_, federated, err := parseAuthority(version.V3ClusterURL, "cluster_0")
+
+	assert.NoError(t, err)
+	assert.False(t, federated)
+}
+
+func TestParseAuthority_OtherType_ShouldFail(t *testing.T) {
+	_, _, err := parseAuthority(version.V3ClusterURL, "xdstp://eu/envoy.config.listener.v3.Listener/listener_0")
+
+	assert.Error(t, err)
+}
+
+func TestWatchResource_UnknownAuthority_ShouldReportError(t *testing.T) {
+	c := newTestClient(ServerConfig{})
+	var watchErr error
+
+	c.WatchCluster("xdstp://us/envoy.config.cluster.v3.Cluster/cluster_0", func(_ []*clusterv3.Cluster, err error) {
+		watchErr = err
+	})
+
+	if assert.Error(t, watchErr) {
+		assert.Contains(t, watchErr.Error(), "unknown authority")
+	}
+	assert.Empty(t, c.DumpResources()[version.V3ClusterURL])
+}
+
+func TestDumpResources_Authorities_ShouldKeyByFullName(t *testing.T) {
+	wildcard := func() *watch { return &watch{watcher: &recordingWatcher{}} }
+	c := newTestClient(ServerConfig{}, wildcard())
+	eu, us := newTestClient(ServerConfig{}, wildcard()), newTestClient(ServerConfig{}, wildcard())
+	c.authorities = map[string]*clientImpl{"eu": eu, "us": us, "local": c}
+	euName := "xdstp://eu/envoy.config.cluster.v3.Cluster/cluster_0"
+	usName := "xdstp://us/envoy.config.cluster.v3.Cluster/cluster_0"
+
+	assert.NoError(t, c.handleResponse(clusterResponse(t, "1", "cluster_0")))
+	assert.NoError(t, eu.handleResponse(clusterResponse(t, "1", euName)))
+	assert.NoError(t, us.handleResponse(clusterResponse(t, "2", usName)))
+
+	dump := c.DumpResources()[version.V3ClusterURL]
+	assert.Len(t, dump, 3)
+	assert.Equal(t, "1", dump[euName].MD.Version)
+	assert.Equal(t, "2", dump[usName].MD.Version)
+}
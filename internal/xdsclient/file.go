@@ -0,0 +1,305 @@
+package xdsclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"gopkg.in/yaml.v3"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/event"
+)
+
+// NewFromFile returns a client serving the resources of an Envoy-style YAML
+// or JSON file instead of fetching them from a management server. The file
+// holds listeners and clusters under static_resources, as in the Envoy
+// bootstrap, and resources of any type, route configurations and cluster
+// load assignments included, in a resources list of Any messages, as in the
+// files of Envoy's path-based config sources:
+//
+//	static_resources:
+//	  listeners: [...]
+//	  clusters: [...]
+//	resources:
+//	- "@type": type.googleapis.com/envoy.config.route.v3.RouteConfiguration
+//	  name: route_config_0
+//	  ...
+//
+// When reloadInterval is positive, the file is checked for changes at that
+// interval and the watchers are updated as if the management server had sent
+// a new version.
+func NewFromFile(path string, reloadInterval time.Duration) (XDSClient, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &clientImpl{
+		ctx:           ctx,
+		cancel:        cancel,
+		node:          newNode(ServerConfig{}),
+		done:          event.NewEvent(),
+		resourceTypes: newResourceTypeRegistry(),
+		streams:       make(map[string]*stream),
+		watches:       make(map[string]*watchState),
+	}
+	c.file = &fileSource{client: c, path: path, applied: make(map[string]bool)}
+	if _, err := c.file.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.file.run(ctx, reloadInterval)
+		}()
+	}
+	return c, nil
+}
+
+// fileSource feeds a client with the resources of a file. Each resource type
+// is handed to the client as a state-of-the-world response once it is first
+// watched, and again whenever the file changes.
+type fileSource struct {
+	client *clientImpl
+	path   string
+
+	mu sync.Mutex
+	// version is the hash of the content last loaded.
+	version string
+	// resources is keyed by type URL.
+	resources map[string][]*any.Any
+	// applied holds the types handed to the client.
+	applied map[string]bool
+}
+
+// load reads the file, and reports whether its content changed since the
+// last load. The last content is kept if the file cannot be read or parsed.
+func (f *fileSource) load() (bool, error) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("fail to read xds config file: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	version := hex.EncodeToString(sum[:8])
+
+	f.mu.Lock()
+	unchanged := version == f.version
+	f.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	resources, err := parseFile(b)
+	if err != nil {
+		return false, fmt.Errorf("fail to parse xds config file %s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = version
+	f.resources = resources
+	return true, nil
+}
+
+// response returns the state-of-the-world response holding the resources of
+// typeURL in the file.
+func (f *fileSource) response(typeURL string) *xdsv3.DiscoveryResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &xdsv3.DiscoveryResponse{
+		TypeUrl:     typeURL,
+		VersionInfo: f.version,
+		Resources:   f.resources[typeURL],
+	}
+}
+
+// subscribe hands the resources of typeURL to the client the first time the
+// type is watched, and reports the watched ones missing from the file as not
+// existing.
+func (f *fileSource) subscribe(typeURL string) {
+	f.mu.Lock()
+	first := !f.applied[typeURL]
+	f.applied[typeURL] = true
+	f.mu.Unlock()
+
+	if first {
+		f.apply(typeURL)
+	} else {
+		f.client.removeAwaited(typeURL)
+	}
+}
+
+func (f *fileSource) apply(typeURL string) {
+	if err := f.client.handleResponse(f.response(typeURL)); err != nil {
+		log.Warn().Err(err).Str("type", typeURL).Str("path", f.path).Msg("invalid resources in xds config file, serving the last loaded ones")
+	}
+	f.client.removeAwaited(typeURL)
+}
+
+// run reloads the file every interval until ctx is done, and hands the
+// resources of every watched type to the client when it changed.
+func (f *fileSource) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := f.load()
+		if err != nil {
+			log.Warn().Err(err).Msg("fail to reload xds config file, serving the last loaded one")
+			continue
+		}
+		if !changed {
+			continue
+		}
+		log.Info().Str("path", f.path).Msg("xds config file reloaded")
+
+		f.mu.Lock()
+		typeURLs := make([]string, 0, len(f.applied))
+		for typeURL := range f.applied {
+			typeURLs = append(typeURLs, typeURL)
+		}
+		f.mu.Unlock()
+		for _, typeURL := range typeURLs {
+			f.apply(typeURL)
+		}
+	}
+}
+
+// removeAwaited reports the watched resources of typeURL that have not been
+// received as not existing, the file source holding every resource there is.
+func (c *clientImpl) removeAwaited(typeURL string) {
+	now := time.Now()
+	c.mu.Lock()
+	ws := c.watchStateLocked(typeURL)
+	var removed []string
+	for name := range ws.names {
+		if r, ok := ws.resources[name]; ok && awaited(r) {
+			ws.stopExpiryTimer(name)
+			ws.remove(name, now)
+			removed = append(removed, name)
+		}
+	}
+	watches := ws.watchList()
+	c.mu.Unlock()
+
+	if len(removed) == 0 {
+		return
+	}
+	c.notifyRemoved(watches, removed)
+	c.notifyDone(watches)
+}
+
+// fileContent is the layout of the files read by NewFromFile.
+type fileContent struct {
+	StaticResources struct {
+		Listeners []json.RawMessage `json:"listeners"`
+		Clusters  []json.RawMessage `json:"clusters"`
+	} `json:"static_resources"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// parseFile returns the resources of a YAML or JSON file, keyed by type URL.
+func parseFile(b []byte) (map[string][]*any.Any, error) {
+	// JSON being a subset of YAML, both are read as YAML and converted to
+	// JSON for protojson.
+	var content interface{}
+	if err := yaml.Unmarshal(b, &content); err != nil {
+		return nil, err
+	}
+	dropUnknownTypes(content)
+	j, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var file fileContent
+	if err := json.Unmarshal(j, &file); err != nil {
+		return nil, err
+	}
+
+	unmarshal := protojson.UnmarshalOptions{Resolver: resourcev3.LenientResolver}
+	resources := make(map[string][]*any.Any)
+	add := func(m proto.Message) error {
+		a, err := anypb.New(m)
+		if err != nil {
+			return err
+		}
+		resources[a.GetTypeUrl()] = append(resources[a.GetTypeUrl()], a)
+		return nil
+	}
+	for _, raw := range file.StaticResources.Listeners {
+		listener := &listenerv3.Listener{}
+		if err := unmarshal.Unmarshal(raw, listener); err != nil {
+			return nil, fmt.Errorf("invalid listener: %w", err)
+		}
+		if err := add(listener); err != nil {
+			return nil, err
+		}
+	}
+	for _, raw := range file.StaticResources.Clusters {
+		cluster := &clusterv3.Cluster{}
+		if err := unmarshal.Unmarshal(raw, cluster); err != nil {
+			return nil, fmt.Errorf("invalid cluster: %w", err)
+		}
+		if err := add(cluster); err != nil {
+			return nil, err
+		}
+	}
+	for _, raw := range file.Resources {
+		a := &anypb.Any{}
+		if err := unmarshal.Unmarshal(raw, a); err != nil {
+			return nil, fmt.Errorf("invalid resource: %w", err)
+		}
+		resources[a.GetTypeUrl()] = append(resources[a.GetTypeUrl()], a)
+	}
+	return resources, nil
+}
+
+// dropUnknownTypes empties the embedded messages of v whose type is not
+// linked in, like the typed configs of Envoy extensions the client does not
+// use, keeping only their type URL. Unknown fields of the other messages, such
+// as misspelled ones, still fail the parsing.
+func dropUnknownTypes(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if url, ok := v["@type"].(string); ok {
+			if _, err := protoregistry.GlobalTypes.FindMessageByURL(url); errors.Is(err, protoregistry.NotFound) {
+				for key := range v {
+					if key != "@type" {
+						delete(v, key)
+					}
+				}
+				return
+			}
+		}
+		for _, value := range v {
+			dropUnknownTypes(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			dropUnknownTypes(value)
+		}
+	}
+}
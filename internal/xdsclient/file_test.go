@@ -0,0 +1,61 @@
+package xdsclient
+
+import (
+	"testing"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+const testFile = `
+static_resources:
+  listeners:
+  - name: listener_0
+    api_listener:
+      api_listener:
+        "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+        rds:
+          route_config_name: route_config_0
+        http_filters:
+        - name: envoy.filters.http.unknown
+          typed_config:
+            "@type": type.googleapis.com/envoy.extensions.filters.http.unknown.v3.Unknown
+            some_field: true
+  clusters:
+  - name: cluster_0
+    type: STATIC
+resources:
+- "@type": type.googleapis.com/envoy.config.route.v3.RouteConfiguration
+  name: route_config_0
+  virtual_hosts:
+  - name: virtual_host_0
+    domains: ["*"]
+`
+
+func TestParseFile_StaticResourcesAndResources_ShouldGroupByType(t *testing.T) {
+	resources, err := parseFile([]byte(testFile))
+
+	assert.NoError(t, err)
+	assert.Len(t, resources[version.V3ListenerURL], 1, "typed configs of unknown extensions should not fail the file")
+	assert.Len(t, resources[version.V3ClusterURL], 1)
+	if assert.Len(t, resources[version.V3RouteConfigURL], 1) {
+		rc := &routev3.RouteConfiguration{}
+		assert.NoError(t, resources[version.V3RouteConfigURL][0].UnmarshalTo(rc))
+		assert.Equal(t, "route_config_0", rc.Name)
+	}
+}
+
+func TestParseFile_InvalidResource_ShouldFail(t *testing.T) {
+	_, err := parseFile([]byte(`{"static_resources": {"clusters": [{"name": 1}]}}`))
+
+	assert.Error(t, err)
+}
+
+func TestParseFile_MisspelledField_ShouldFail(t *testing.T) {
+	_, err := parseFile([]byte(`{"static_resources": {"clusters": [{"name": "cluster_0", "lb_polcy": "RANDOM"}]}}`))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "lb_polcy")
+}
@@ -0,0 +1,62 @@
+package xdsclient
+
+import (
+	"runtime/debug"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	modulePath    = "github.com/k3rn3l-p4n1c/gohttpxds"
+	userAgentName = "gohttpxds"
+
+	// clientFeatureNoOverprovisioning tells the management server that
+	// locality weights are used as is, without an overprovisioning factor.
+	clientFeatureNoOverprovisioning = "envoy.lb.does_not_support_overprovisioning"
+)
+
+// newNode returns the node identity sent to the management server. It is a
+// copy of config.Node with the id overridden by config.NodeId and the user
+// agent and client features of this library filled in.
+func newNode(config ServerConfig) *corev3.Node {
+	node := &corev3.Node{}
+	if config.Node != nil {
+		node = proto.Clone(config.Node).(*corev3.Node)
+	}
+	if config.NodeId != "" {
+		node.Id = config.NodeId
+	}
+
+	if node.UserAgentName == "" {
+		node.UserAgentName = userAgentName
+	}
+	if node.UserAgentVersionType == nil {
+		node.UserAgentVersionType = &corev3.Node_UserAgentVersion{UserAgentVersion: moduleVersion()}
+	}
+
+	for _, feature := range node.ClientFeatures {
+		if feature == clientFeatureNoOverprovisioning {
+			return node
+		}
+	}
+	node.ClientFeatures = append(node.ClientFeatures, clientFeatureNoOverprovisioning)
+	return node
+}
+
+// moduleVersion returns the version of this module in the running binary.
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,41 @@
+package xdsclient
+
+import (
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNode_NodeId_ShouldOverrideBootstrapId(t *testing.T) {
+	config := ServerConfig{
+		NodeId: "override",
+		Node:   &corev3.Node{Id: "bootstrap", Cluster: "app"},
+	}
+
+	node := newNode(config)
+
+	assert.Equal(t, "override", node.GetId())
+	assert.Equal(t, "app", node.GetCluster())
+	assert.Equal(t, "bootstrap", config.Node.GetId(), "configured node should not be modified")
+}
+
+func TestNewNode_ShouldFillUserAgentAndClientFeatures(t *testing.T) {
+	node := newNode(ServerConfig{NodeId: "node"})
+
+	assert.Equal(t, userAgentName, node.GetUserAgentName())
+	assert.NotEmpty(t, node.GetUserAgentVersion())
+	assert.Equal(t, []string{clientFeatureNoOverprovisioning}, node.GetClientFeatures())
+}
+
+func TestNewNode_ConfiguredUserAgent_ShouldBeKept(t *testing.T) {
+	node := newNode(ServerConfig{Node: &corev3.Node{
+		UserAgentName:        "app",
+		UserAgentVersionType: &corev3.Node_UserAgentVersion{UserAgentVersion: "1.0"},
+		ClientFeatures:       []string{clientFeatureNoOverprovisioning},
+	}})
+
+	assert.Equal(t, "app", node.GetUserAgentName())
+	assert.Equal(t, "1.0", node.GetUserAgentVersion())
+	assert.Equal(t, []string{clientFeatureNoOverprovisioning}, node.GetClientFeatures())
+}
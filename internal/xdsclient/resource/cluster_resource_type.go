@@ -0,0 +1,80 @@
+package xdsresource
+
+import (
+	"fmt"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// ClusterType is the Type of xDS Cluster resources. Clusters missing from a
+// state-of-the-world response are deleted.
+var ClusterType Type = clusterResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:                    version.V3ClusterURL,
+		typeEnum:                   ClusterResource,
+		allResourcesRequiredInSotW: true,
+	},
+}
+
+type clusterResourceType struct {
+	resourceTypeState
+}
+
+func (clusterResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	cluster := &clusterv3.Cluster{}
+	if err := unmarshalResource(r, version.V3ClusterURL, cluster); err != nil {
+		return nil, err
+	}
+	if err := validateCluster(cluster); err != nil {
+		return &DecodeResult{Name: cluster.GetName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     cluster.GetName(),
+		Resource: &ClusterResourceData{Resource: cluster, raw: raw},
+	}, nil
+}
+
+// validateCluster checks that the endpoints of an EDS cluster can be resolved,
+// and that the ones of its inline load assignment can be sent requests.
+// Clusters of other discovery types, like Istio's ORIGINAL_DST passthrough or
+// its endpoint-less STATIC black hole, are accepted as they are.
+func validateCluster(c *clusterv3.Cluster) error {
+	if c.GetName() == "" {
+		return fmt.Errorf("cluster has no name")
+	}
+
+	if c.GetType() == clusterv3.Cluster_EDS && c.GetEdsClusterConfig() == nil {
+		return fmt.Errorf("cluster %q is of type EDS but has no eds_cluster_config", c.GetName())
+	}
+	if err := validateLbEndpoints(c.GetLoadAssignment().GetEndpoints()); err != nil {
+		return fmt.Errorf("load assignment of cluster %q %w", c.GetName(), err)
+	}
+	return nil
+}
+
+// ClusterResourceData is the ResourceData of a Cluster.
+type ClusterResourceData struct {
+	Resource *clusterv3.Cluster
+	raw      *anypb.Any
+}
+
+func (*ClusterResourceData) isResourceData() {}
+
+func (c *ClusterResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*ClusterResourceData)
+	return ok && proto.Equal(c.Resource, o.Resource)
+}
+
+func (c *ClusterResourceData) ToJSON() string {
+	return resourceToJSON(c.Resource)
+}
+
+func (c *ClusterResourceData) Raw() *anypb.Any {
+	return c.raw
+}
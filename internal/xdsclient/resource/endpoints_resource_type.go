@@ -0,0 +1,92 @@
+package xdsresource
+
+import (
+	"fmt"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// EndpointsType is the Type of xDS ClusterLoadAssignment resources, named
+// after the cluster or EDS service they belong to.
+var EndpointsType Type = endpointsResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:  version.V3EndpointsURL,
+		typeEnum: EndpointsResource,
+	},
+}
+
+type endpointsResourceType struct {
+	resourceTypeState
+}
+
+func (endpointsResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	cla := &endpointv3.ClusterLoadAssignment{}
+	if err := unmarshalResource(r, version.V3EndpointsURL, cla); err != nil {
+		return nil, err
+	}
+	if err := validateClusterLoadAssignment(cla); err != nil {
+		return &DecodeResult{Name: cla.GetClusterName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     cla.GetClusterName(),
+		Resource: &EndpointsResourceData{Resource: cla, raw: raw},
+	}, nil
+}
+
+// validateClusterLoadAssignment checks that every endpoint of cla has a socket
+// address requests can be sent to.
+func validateClusterLoadAssignment(cla *endpointv3.ClusterLoadAssignment) error {
+	if cla.GetClusterName() == "" {
+		return fmt.Errorf("cluster load assignment has no cluster_name")
+	}
+	if err := validateLbEndpoints(cla.GetEndpoints()); err != nil {
+		return fmt.Errorf("cluster load assignment %q %w", cla.GetClusterName(), err)
+	}
+	return nil
+}
+
+// validateLbEndpoints checks that every endpoint has a socket address with a
+// port value, named ports and pipes not being supported. The returned error
+// completes the description of what holds the endpoints.
+func validateLbEndpoints(endpoints []*endpointv3.LocalityLbEndpoints) error {
+	for _, locality := range endpoints {
+		for _, lbEndpoint := range locality.GetLbEndpoints() {
+			address := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()
+			if address == nil {
+				return fmt.Errorf("has an endpoint without a socket address")
+			}
+			if _, ok := address.GetPortSpecifier().(*corev3.SocketAddress_PortValue); !ok {
+				return fmt.Errorf("has an endpoint without a port value")
+			}
+		}
+	}
+	return nil
+}
+
+// EndpointsResourceData is the ResourceData of a ClusterLoadAssignment.
+type EndpointsResourceData struct {
+	Resource *endpointv3.ClusterLoadAssignment
+	raw      *anypb.Any
+}
+
+func (*EndpointsResourceData) isResourceData() {}
+
+func (e *EndpointsResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*EndpointsResourceData)
+	return ok && proto.Equal(e.Resource, o.Resource)
+}
+
+func (e *EndpointsResourceData) ToJSON() string {
+	return resourceToJSON(e.Resource)
+}
+
+func (e *EndpointsResourceData) Raw() *anypb.Any {
+	return e.raw
+}
@@ -0,0 +1,112 @@
+package xdsresource
+
+import (
+	"fmt"
+
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// ListenerType is the Type of xDS Listener resources. Listeners missing from
+// a state-of-the-world response are deleted.
+var ListenerType Type = listenerResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:                    version.V3ListenerURL,
+		typeEnum:                   ListenerResource,
+		allResourcesRequiredInSotW: true,
+	},
+}
+
+type listenerResourceType struct {
+	resourceTypeState
+}
+
+func (listenerResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	listener := &listenerv3.Listener{}
+	if err := unmarshalResource(r, version.V3ListenerURL, listener); err != nil {
+		return nil, err
+	}
+	if err := validateListener(listener); err != nil {
+		return &DecodeResult{Name: listener.GetName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     listener.GetName(),
+		Resource: &ListenerResourceData{Resource: listener, raw: raw},
+	}, nil
+}
+
+// validateListener checks that every HttpConnectionManager of l, either as
+// its API listener or in its filter chains, can be used.
+func validateListener(l *listenerv3.Listener) error {
+	if l.GetName() == "" {
+		return fmt.Errorf("listener has no name")
+	}
+
+	if api := l.GetApiListener().GetApiListener(); api != nil {
+		if err := validateHTTPConnManager(api); err != nil {
+			return fmt.Errorf("listener %q: %w", l.GetName(), err)
+		}
+	}
+	for _, filterChain := range l.GetFilterChains() {
+		for _, filter := range filterChain.GetFilters() {
+			if !IsHTTPConnManagerResource(filter.GetTypedConfig().GetTypeUrl()) {
+				continue
+			}
+			if err := validateHTTPConnManager(filter.GetTypedConfig()); err != nil {
+				return fmt.Errorf("listener %q: filter %q: %w", l.GetName(), filter.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateHTTPConnManager(r *anypb.Any) error {
+	manager := &hcmv3.HttpConnectionManager{}
+	if err := proto.Unmarshal(r.GetValue(), manager); err != nil {
+		return fmt.Errorf("failed to unmarshal HttpConnectionManager: %w", err)
+	}
+	switch specifier := manager.GetRouteSpecifier().(type) {
+	case *hcmv3.HttpConnectionManager_Rds:
+		if specifier.Rds.GetRouteConfigName() == "" {
+			return fmt.Errorf("HttpConnectionManager has an empty route_config_name")
+		}
+	case *hcmv3.HttpConnectionManager_RouteConfig:
+		for _, vh := range specifier.RouteConfig.GetVirtualHosts() {
+			if err := validateVirtualHost(vh); err != nil {
+				return fmt.Errorf("HttpConnectionManager: inline route configuration: %w", err)
+			}
+		}
+	case *hcmv3.HttpConnectionManager_ScopedRoutes:
+		if err := validateScopedRoutes(specifier.ScopedRoutes); err != nil {
+			return fmt.Errorf("HttpConnectionManager: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListenerResourceData is the ResourceData of a Listener.
+type ListenerResourceData struct {
+	Resource *listenerv3.Listener
+	raw      *anypb.Any
+}
+
+func (*ListenerResourceData) isResourceData() {}
+
+func (l *ListenerResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*ListenerResourceData)
+	return ok && proto.Equal(l.Resource, o.Resource)
+}
+
+func (l *ListenerResourceData) ToJSON() string {
+	return resourceToJSON(l.Resource)
+}
+
+func (l *ListenerResourceData) Raw() *anypb.Any {
+	return l.raw
+}
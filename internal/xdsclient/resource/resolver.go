@@ -0,0 +1,47 @@
+package xdsresource
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// LenientResolver resolves the types of the messages embedded in resources
+// when converting them from and to JSON. Types that are not linked in, like
+// the typed configs of Envoy extensions the client does not use, resolve to a
+// message without fields so that they keep their type URL instead of failing
+// the conversion. Their content is lost, so it must be discarded when
+// unmarshalling.
+var LenientResolver = lenientResolver{protoregistry.GlobalTypes}
+
+type lenientResolver struct {
+	*protoregistry.Types
+}
+
+func (r lenientResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	mt, err := r.Types.FindMessageByURL(url)
+	if errors.Is(err, protoregistry.NotFound) {
+		return unknownMessageType, nil
+	}
+	return mt, err
+}
+
+// unknownMessageType stands for the types LenientResolver does not know. It
+// is not a well-known type, whose JSON form inside an Any differs.
+var unknownMessageType = func() protoreflect.MessageType {
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("gohttpxds/unknown.proto"),
+		Package:     proto.String("gohttpxds"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Unknown")}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return dynamicpb.NewMessageType(file.Messages().Get(0))
+}()
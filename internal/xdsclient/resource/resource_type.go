@@ -18,6 +18,10 @@
 package xdsresource
 
 import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
@@ -57,6 +61,23 @@ type ResourceWatcher interface {
 	OnResourceDoesNotExist()
 }
 
+// ResourceRemovedWatcher is optionally implemented by the watchers of every
+// resource of a type, to which OnResourceDoesNotExist cannot tell which
+// resource is gone.
+type ResourceRemovedWatcher interface {
+	// OnResourceRemoved is invoked when the named resource is removed by the
+	// management server.
+	OnResourceRemoved(name string)
+}
+
+// BatchWatcher is optionally implemented by watchers that want to apply the
+// updates and removals caused by a single response at once.
+type BatchWatcher interface {
+	// OnBatchDone is invoked once the last update or removal of a response
+	// has been delivered.
+	OnBatchDone()
+}
+
 // TODO: Once the implementation is complete, rename this interface as
 // ResourceType and get rid of the existing ResourceType enum.
 
@@ -85,8 +106,9 @@ type Type interface {
 	// provided `Any` proto, as received from the xDS management server.
 	//
 	// If protobuf deserialization fails or resource validation fails,
-	// returns a non-nil error. Otherwise, returns a fully populated
-	// DecodeResult.
+	// returns a non-nil error. When only validation fails, the returned
+	// DecodeResult also carries the name of the rejected resource, if it has
+	// one. Otherwise, returns a fully populated DecodeResult.
 	Decode(*anypb.Any) (*DecodeResult, error)
 }
 
@@ -136,3 +158,29 @@ func (r resourceTypeState) TypeEnum() ResourceType {
 func (r resourceTypeState) AllResourcesRequiredInSotW() bool {
 	return r.allResourcesRequiredInSotW
 }
+
+// unmarshalResource unwraps r if needed, checks that it is of the given type
+// and unmarshals it into m.
+func unmarshalResource(r *anypb.Any, typeURL string, m proto.Message) error {
+	r, err := unwrapResource(r)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap resource: %w", err)
+	}
+	if r.GetTypeUrl() != typeURL {
+		return fmt.Errorf("resource has type %q, want %q", r.GetTypeUrl(), typeURL)
+	}
+	if err := proto.Unmarshal(r.GetValue(), m); err != nil {
+		return fmt.Errorf("failed to unmarshal resource: %w", err)
+	}
+	return nil
+}
+
+// resourceToJSON returns the JSON representation of m, or an empty string if
+// it cannot be marshalled.
+func resourceToJSON(m proto.Message) string {
+	b, err := protojson.MarshalOptions{Multiline: true}.Marshal(proto.MessageV2(m))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,257 @@
+package xdsresource
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func mustMarshal(t *testing.T, m proto.Message) *anypb.Any {
+	raw, err := anypb.New(proto.MessageV2(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestListenerTypeDecode_ValidListener_ShouldDecode(t *testing.T) {
+	raw := mustMarshal(t, &listenerv3.Listener{Name: "listener_0"})
+
+	result, err := ListenerType.Decode(raw)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "listener_0", result.Name)
+	assert.Equal(t, "listener_0", result.Resource.(*ListenerResourceData).Resource.GetName())
+	assert.True(t, proto.Equal(raw, result.Resource.Raw()))
+}
+
+func TestListenerTypeDecode_WrappedListener_ShouldUnwrap(t *testing.T) {
+	inner := mustMarshal(t, &listenerv3.Listener{Name: "listener_0"})
+	raw := mustMarshal(t, &v3discoverypb.Resource{Resource: inner})
+
+	result, err := ListenerType.Decode(raw)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "listener_0", result.Name)
+	assert.True(t, proto.Equal(inner, result.Resource.Raw()))
+}
+
+func TestListenerTypeDecode_EmptyRouteConfigName_ShouldFail(t *testing.T) {
+	manager := mustMarshal(t, &hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{Rds: &hcmv3.Rds{}},
+	})
+	raw := mustMarshal(t, &listenerv3.Listener{
+		Name:        "listener_0",
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	})
+
+	_, err := ListenerType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestRouteConfigTypeDecode_VirtualHostWithoutDomains_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &routev3.RouteConfiguration{
+		Name:         "route_config_0",
+		VirtualHosts: []*routev3.VirtualHost{{Name: "virtual_host_0"}},
+	})
+
+	_, err := RouteConfigType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestRouteConfigTypeDecode_RouteWithoutCluster_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &routev3.RouteConfiguration{
+		Name: "route_config_0",
+		VirtualHosts: []*routev3.VirtualHost{{
+			Name:    "virtual_host_0",
+			Domains: []string{"*"},
+			Routes: []*routev3.Route{{
+				Match:  &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &routev3.Route_Route{Route: &routev3.RouteAction{}},
+			}},
+		}},
+	})
+
+	_, err := RouteConfigType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestVirtualHostTypeDecode_ValidVirtualHost_ShouldDecode(t *testing.T) {
+	raw := mustMarshal(t, &routev3.VirtualHost{Name: VirtualHostName("route_config_0", "service"), Domains: []string{"service"}})
+
+	result, err := VirtualHostType.Decode(raw)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "route_config_0/service", result.Name)
+	assert.True(t, IsVirtualHostOf(result.Name, "route_config_0"))
+	assert.False(t, IsVirtualHostOf(result.Name, "route_config"))
+}
+
+func TestVirtualHostTypeDecode_NoDomains_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &routev3.VirtualHost{Name: "route_config_0/service"})
+
+	_, err := VirtualHostType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestListenerTypeDecode_InlineVirtualHostWithoutDomains_ShouldFail(t *testing.T) {
+	manager := mustMarshal(t, &hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_RouteConfig{RouteConfig: &routev3.RouteConfiguration{
+			VirtualHosts: []*routev3.VirtualHost{{Name: "virtual_host_0"}},
+		}},
+	})
+	raw := mustMarshal(t, &listenerv3.Listener{
+		Name:        "listener_0",
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	})
+
+	_, err := ListenerType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestListenerTypeDecode_ScopedRoutesWithoutKeyBuilder_ShouldFail(t *testing.T) {
+	manager := mustMarshal(t, &hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_ScopedRoutes{ScopedRoutes: &hcmv3.ScopedRoutes{
+			Name:            "scoped_routes_0",
+			ConfigSpecifier: &hcmv3.ScopedRoutes_ScopedRds{ScopedRds: &hcmv3.ScopedRds{}},
+		}},
+	})
+	raw := mustMarshal(t, &listenerv3.Listener{
+		Name:        "listener_0",
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	})
+
+	_, err := ListenerType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestScopedRouteConfigTypeDecode_ValidScope_ShouldDecode(t *testing.T) {
+	raw := mustMarshal(t, &routev3.ScopedRouteConfiguration{
+		Name:                   "scope_0",
+		RouteConfigurationName: "route_config_0",
+		Key: &routev3.ScopedRouteConfiguration_Key{
+			Fragments: []*routev3.ScopedRouteConfiguration_Key_Fragment{{
+				Type: &routev3.ScopedRouteConfiguration_Key_Fragment_StringKey{StringKey: "tenant_0"},
+			}},
+		},
+	})
+
+	result, err := ScopedRouteConfigType.Decode(raw)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "scope_0", result.Name)
+	assert.Equal(t, "route_config_0", result.Resource.(*ScopedRouteConfigResourceData).Resource.GetRouteConfigurationName())
+}
+
+func TestScopedRouteConfigTypeDecode_NoKey_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &routev3.ScopedRouteConfiguration{Name: "scope_0", RouteConfigurationName: "route_config_0"})
+
+	_, err := ScopedRouteConfigType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestClusterTypeDecode_EDSClusterWithoutConfig_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &clusterv3.Cluster{
+		Name:                 "cluster_0",
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+	})
+
+	_, err := ClusterType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestClusterTypeDecode_OriginalDstCluster_ShouldDecode(t *testing.T) {
+	raw := mustMarshal(t, &clusterv3.Cluster{
+		Name:                 "PassthroughCluster",
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_ORIGINAL_DST},
+	})
+
+	_, err := ClusterType.Decode(raw)
+
+	assert.NoError(t, err)
+}
+
+func TestEndpointsTypeDecode_EndpointWithoutAddress_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &endpointv3.ClusterLoadAssignment{
+		ClusterName: "cluster_0",
+		Endpoints: []*endpointv3.LocalityLbEndpoints{{
+			LbEndpoints: []*endpointv3.LbEndpoint{{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{},
+				}},
+			}},
+		}},
+	})
+
+	_, err := EndpointsType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestEndpointsTypeDecode_NamedPort_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &endpointv3.ClusterLoadAssignment{
+		ClusterName: "cluster_0",
+		Endpoints: []*endpointv3.LocalityLbEndpoints{{
+			LbEndpoints: []*endpointv3.LbEndpoint{{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+						Address:       "10.0.0.1",
+						PortSpecifier: &corev3.SocketAddress_NamedPort{NamedPort: "http"},
+					}}},
+				}},
+			}},
+		}},
+	})
+
+	_, err := EndpointsType.Decode(raw)
+
+	assert.Error(t, err)
+}
+
+func TestClusterTypeDecode_InlineEndpointWithPipeAddress_ShouldFail(t *testing.T) {
+	raw := mustMarshal(t, &clusterv3.Cluster{
+		Name:                 "cluster_0",
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC},
+		LoadAssignment: &endpointv3.ClusterLoadAssignment{
+			Endpoints: []*endpointv3.LocalityLbEndpoints{{
+				LbEndpoints: []*endpointv3.LbEndpoint{{
+					HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+						Address: &corev3.Address{Address: &corev3.Address_Pipe{Pipe: &corev3.Pipe{Path: "/tmp/upstream.sock"}}},
+					}},
+				}},
+			}},
+		},
+	})
+
+	result, err := ClusterType.Decode(raw)
+
+	assert.Error(t, err)
+	assert.Equal(t, "cluster_0", result.Name)
+}
+
+func TestResourceDataEqual_SameResource_ShouldBeEqual(t *testing.T) {
+	a := &ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}}
+	b := &ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_0"}}
+	c := &ClusterResourceData{Resource: &clusterv3.Cluster{Name: "cluster_1"}}
+
+	assert.True(t, a.Equal(b))
+	assert.False(t, a.Equal(c))
+	assert.False(t, a.Equal(&ListenerResourceData{Resource: &listenerv3.Listener{Name: "cluster_0"}}))
+}
@@ -0,0 +1,92 @@
+package xdsresource
+
+import (
+	"fmt"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// RouteConfigType is the Type of xDS RouteConfiguration resources.
+var RouteConfigType Type = routeConfigResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:  version.V3RouteConfigURL,
+		typeEnum: RouteConfigResource,
+	},
+}
+
+type routeConfigResourceType struct {
+	resourceTypeState
+}
+
+func (routeConfigResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	routeConfig := &routev3.RouteConfiguration{}
+	if err := unmarshalResource(r, version.V3RouteConfigURL, routeConfig); err != nil {
+		return nil, err
+	}
+	if err := validateRouteConfig(routeConfig); err != nil {
+		return &DecodeResult{Name: routeConfig.GetName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     routeConfig.GetName(),
+		Resource: &RouteConfigResourceData{Resource: routeConfig, raw: raw},
+	}, nil
+}
+
+// validateRouteConfig checks that every virtual host of rc can be matched and
+// that every route forwarding requests names its cluster.
+func validateRouteConfig(rc *routev3.RouteConfiguration) error {
+	if rc.GetName() == "" {
+		return fmt.Errorf("route configuration has no name")
+	}
+
+	for _, vh := range rc.GetVirtualHosts() {
+		if err := validateVirtualHost(vh); err != nil {
+			return fmt.Errorf("route configuration %q: %w", rc.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// validateVirtualHost checks that vh can be matched and that every route
+// forwarding requests names its cluster.
+func validateVirtualHost(vh *routev3.VirtualHost) error {
+	if len(vh.GetDomains()) == 0 {
+		return fmt.Errorf("virtual host %q has no domains", vh.GetName())
+	}
+	for _, route := range vh.GetRoutes() {
+		if route.GetMatch() == nil {
+			return fmt.Errorf("route %q has no match", route.GetName())
+		}
+		if action := route.GetRoute(); action != nil && action.GetClusterSpecifier() == nil {
+			return fmt.Errorf("route %q has no cluster", route.GetName())
+		}
+	}
+	return nil
+}
+
+// RouteConfigResourceData is the ResourceData of a RouteConfiguration.
+type RouteConfigResourceData struct {
+	Resource *routev3.RouteConfiguration
+	raw      *anypb.Any
+}
+
+func (*RouteConfigResourceData) isResourceData() {}
+
+func (r *RouteConfigResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*RouteConfigResourceData)
+	return ok && proto.Equal(r.Resource, o.Resource)
+}
+
+func (r *RouteConfigResourceData) ToJSON() string {
+	return resourceToJSON(r.Resource)
+}
+
+func (r *RouteConfigResourceData) Raw() *anypb.Any {
+	return r.raw
+}
@@ -0,0 +1,113 @@
+package xdsresource
+
+import (
+	"fmt"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// ScopedRouteConfigType is the Type of the ScopedRouteConfiguration resources
+// fetched through SRDS for the HTTP connection managers routing with scoped
+// routes. Every resource of the type is watched at once, and state-of-the-world
+// responses carry all of them.
+var ScopedRouteConfigType Type = scopedRouteConfigResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:                    version.V3ScopedRouteConfigURL,
+		typeEnum:                   ScopedRouteConfigResource,
+		allResourcesRequiredInSotW: true,
+	},
+}
+
+type scopedRouteConfigResourceType struct {
+	resourceTypeState
+}
+
+func (scopedRouteConfigResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	scope := &routev3.ScopedRouteConfiguration{}
+	if err := unmarshalResource(r, version.V3ScopedRouteConfigURL, scope); err != nil {
+		return nil, err
+	}
+	if err := validateScopedRouteConfig(scope); err != nil {
+		return &DecodeResult{Name: scope.GetName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     scope.GetName(),
+		Resource: &ScopedRouteConfigResourceData{Resource: scope, raw: raw},
+	}, nil
+}
+
+// validateScopedRouteConfig checks that scope names the route configuration
+// it selects and has a key made of strings.
+func validateScopedRouteConfig(scope *routev3.ScopedRouteConfiguration) error {
+	if scope.GetName() == "" {
+		return fmt.Errorf("scoped route configuration has no name")
+	}
+	if scope.GetRouteConfigurationName() == "" {
+		return fmt.Errorf("scoped route configuration %q has no route_configuration_name", scope.GetName())
+	}
+	if len(scope.GetKey().GetFragments()) == 0 {
+		return fmt.Errorf("scoped route configuration %q has no key", scope.GetName())
+	}
+	for _, fragment := range scope.GetKey().GetFragments() {
+		if _, ok := fragment.GetType().(*routev3.ScopedRouteConfiguration_Key_Fragment_StringKey); !ok {
+			return fmt.Errorf("scoped route configuration %q has a key fragment that is not a string", scope.GetName())
+		}
+	}
+	return nil
+}
+
+// validateScopedRoutes checks that the scope key of requests can be built and
+// that the scopes are listed or fetched through SRDS.
+func validateScopedRoutes(scoped *hcmv3.ScopedRoutes) error {
+	fragments := scoped.GetScopeKeyBuilder().GetFragments()
+	if len(fragments) == 0 {
+		return fmt.Errorf("scoped routes %q have no scope key fragments", scoped.GetName())
+	}
+	for _, fragment := range fragments {
+		if fragment.GetHeaderValueExtractor().GetName() == "" {
+			return fmt.Errorf("scoped routes %q have a scope key fragment without header", scoped.GetName())
+		}
+	}
+
+	switch specifier := scoped.GetConfigSpecifier().(type) {
+	case *hcmv3.ScopedRoutes_ScopedRouteConfigurationsList:
+		for _, scope := range specifier.ScopedRouteConfigurationsList.GetScopedRouteConfigurations() {
+			if err := validateScopedRouteConfig(scope); err != nil {
+				return fmt.Errorf("scoped routes %q: %w", scoped.GetName(), err)
+			}
+		}
+	case *hcmv3.ScopedRoutes_ScopedRds:
+	default:
+		return fmt.Errorf("scoped routes %q have neither scopes nor scoped_rds", scoped.GetName())
+	}
+	return nil
+}
+
+// ScopedRouteConfigResourceData is the ResourceData of a
+// ScopedRouteConfiguration.
+type ScopedRouteConfigResourceData struct {
+	Resource *routev3.ScopedRouteConfiguration
+	raw      *anypb.Any
+}
+
+func (*ScopedRouteConfigResourceData) isResourceData() {}
+
+func (r *ScopedRouteConfigResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*ScopedRouteConfigResourceData)
+	return ok && proto.Equal(r.Resource, o.Resource)
+}
+
+func (r *ScopedRouteConfigResourceData) ToJSON() string {
+	return resourceToJSON(r.Resource)
+}
+
+func (r *ScopedRouteConfigResourceData) Raw() *anypb.Any {
+	return r.raw
+}
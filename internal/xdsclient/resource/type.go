@@ -148,6 +148,8 @@ const (
 	RouteConfigResource
 	ClusterResource
 	EndpointsResource
+	VirtualHostResource
+	ScopedRouteConfigResource
 )
 
 func (r ResourceType) String() string {
@@ -162,6 +164,10 @@ func (r ResourceType) String() string {
 		return "ClusterResource"
 	case EndpointsResource:
 		return "EndpointsResource"
+	case VirtualHostResource:
+		return "VirtualHostResource"
+	case ScopedRouteConfigResource:
+		return "ScopedRouteConfigResource"
 	default:
 		return "UnknownResource"
 	}
@@ -175,11 +181,13 @@ var v2ResourceTypeToURL = map[ResourceType]string{
 	EndpointsResource:       version.V2EndpointsURL,
 }
 var v3ResourceTypeToURL = map[ResourceType]string{
-	ListenerResource:        version.V3ListenerURL,
-	HTTPConnManagerResource: version.V3HTTPConnManagerURL,
-	RouteConfigResource:     version.V3RouteConfigURL,
-	ClusterResource:         version.V3ClusterURL,
-	EndpointsResource:       version.V3EndpointsURL,
+	ListenerResource:          version.V3ListenerURL,
+	HTTPConnManagerResource:   version.V3HTTPConnManagerURL,
+	RouteConfigResource:       version.V3RouteConfigURL,
+	ClusterResource:           version.V3ClusterURL,
+	EndpointsResource:         version.V3EndpointsURL,
+	VirtualHostResource:       version.V3VirtualHostURL,
+	ScopedRouteConfigResource: version.V3ScopedRouteConfigURL,
 }
 
 // URL returns the transport protocol specific resource type URL.
@@ -200,16 +208,18 @@ func (r ResourceType) URL(v version.TransportAPI) string {
 }
 
 var urlToResourceType = map[string]ResourceType{
-	version.V2ListenerURL:        ListenerResource,
-	version.V2RouteConfigURL:     RouteConfigResource,
-	version.V2ClusterURL:         ClusterResource,
-	version.V2EndpointsURL:       EndpointsResource,
-	version.V2HTTPConnManagerURL: HTTPConnManagerResource,
-	version.V3ListenerURL:        ListenerResource,
-	version.V3RouteConfigURL:     RouteConfigResource,
-	version.V3ClusterURL:         ClusterResource,
-	version.V3EndpointsURL:       EndpointsResource,
-	version.V3HTTPConnManagerURL: HTTPConnManagerResource,
+	version.V2ListenerURL:          ListenerResource,
+	version.V2RouteConfigURL:       RouteConfigResource,
+	version.V2ClusterURL:           ClusterResource,
+	version.V2EndpointsURL:         EndpointsResource,
+	version.V2HTTPConnManagerURL:   HTTPConnManagerResource,
+	version.V3ListenerURL:          ListenerResource,
+	version.V3RouteConfigURL:       RouteConfigResource,
+	version.V3ClusterURL:           ClusterResource,
+	version.V3EndpointsURL:         EndpointsResource,
+	version.V3HTTPConnManagerURL:   HTTPConnManagerResource,
+	version.V3VirtualHostURL:       VirtualHostResource,
+	version.V3ScopedRouteConfigURL: ScopedRouteConfigResource,
 }
 
 // ResourceTypeFromURL returns the xDS resource type associated with the given
@@ -49,16 +49,20 @@ const (
 	V2EndpointsURL       = googleapiPrefix + V2EndpointsType
 	V2HTTPConnManagerURL = googleapiPrefix + "envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager"
 
-	V3ListenerType    = "envoy.config.listener.v3.Listener"
-	V3RouteConfigType = "envoy.config.route.v3.RouteConfiguration"
-	V3ClusterType     = "envoy.config.cluster.v3.Cluster"
-	V3EndpointsType   = "envoy.config.endpoint.v3.ClusterLoadAssignment"
+	V3ListenerType          = "envoy.config.listener.v3.Listener"
+	V3RouteConfigType       = "envoy.config.route.v3.RouteConfiguration"
+	V3ClusterType           = "envoy.config.cluster.v3.Cluster"
+	V3EndpointsType         = "envoy.config.endpoint.v3.ClusterLoadAssignment"
+	V3VirtualHostType       = "envoy.config.route.v3.VirtualHost"
+	V3ScopedRouteConfigType = "envoy.config.route.v3.ScopedRouteConfiguration"
 
 	V3ResourceWrapperURL      = googleapiPrefix + "envoy.service.discovery.v3.Resource"
 	V3ListenerURL             = googleapiPrefix + V3ListenerType
 	V3RouteConfigURL          = googleapiPrefix + V3RouteConfigType
 	V3ClusterURL              = googleapiPrefix + V3ClusterType
 	V3EndpointsURL            = googleapiPrefix + V3EndpointsType
+	V3VirtualHostURL          = googleapiPrefix + V3VirtualHostType
+	V3ScopedRouteConfigURL    = googleapiPrefix + V3ScopedRouteConfigType
 	V3HTTPConnManagerURL      = googleapiPrefix + "envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
 	V3UpstreamTLSContextURL   = googleapiPrefix + "envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext"
 	V3DownstreamTLSContextURL = googleapiPrefix + "envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext"
@@ -0,0 +1,81 @@
+package xdsresource
+
+import (
+	"fmt"
+	"strings"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+// VirtualHostType is the Type of the VirtualHost resources fetched on demand
+// through VHDS for the route configurations enabling it. VHDS only exists in
+// the incremental protocol, where a virtual host is subscribed to as
+// "<route configuration name>/<host>" and sent back under that name, or with
+// that name among the aliases of its Resource wrapper. The name decoded from
+// the VirtualHost itself is only used when the wrapper has none.
+var VirtualHostType Type = virtualHostResourceType{
+	resourceTypeState: resourceTypeState{
+		typeURL:  version.V3VirtualHostURL,
+		typeEnum: VirtualHostResource,
+	},
+}
+
+// VirtualHostName returns the name of the VirtualHost resource of host in
+// the route configuration routeConfigName.
+func VirtualHostName(routeConfigName, host string) string {
+	return routeConfigName + "/" + host
+}
+
+// IsVirtualHostOf reports whether the VirtualHost resource named name belongs
+// to the route configuration routeConfigName.
+func IsVirtualHostOf(name, routeConfigName string) bool {
+	return strings.HasPrefix(name, routeConfigName+"/")
+}
+
+type virtualHostResourceType struct {
+	resourceTypeState
+}
+
+func (virtualHostResourceType) Decode(r *anypb.Any) (*DecodeResult, error) {
+	vh := &routev3.VirtualHost{}
+	if err := unmarshalResource(r, version.V3VirtualHostURL, vh); err != nil {
+		return nil, err
+	}
+	if vh.GetName() == "" {
+		return nil, fmt.Errorf("virtual host has no name")
+	}
+	if err := validateVirtualHost(vh); err != nil {
+		return &DecodeResult{Name: vh.GetName()}, err
+	}
+
+	raw, _ := unwrapResource(r)
+	return &DecodeResult{
+		Name:     vh.GetName(),
+		Resource: &VirtualHostResourceData{Resource: vh, raw: raw},
+	}, nil
+}
+
+// VirtualHostResourceData is the ResourceData of a VirtualHost.
+type VirtualHostResourceData struct {
+	Resource *routev3.VirtualHost
+	raw      *anypb.Any
+}
+
+func (*VirtualHostResourceData) isResourceData() {}
+
+func (r *VirtualHostResourceData) Equal(other ResourceData) bool {
+	o, ok := other.(*VirtualHostResourceData)
+	return ok && proto.Equal(r.Resource, o.Resource)
+}
+
+func (r *VirtualHostResourceData) ToJSON() string {
+	return resourceToJSON(r.Resource)
+}
+
+func (r *VirtualHostResourceData) Raw() *anypb.Any {
+	return r.raw
+}
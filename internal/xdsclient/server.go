@@ -0,0 +1,135 @@
+package xdsclient
+
+import (
+	"fmt"
+
+	cdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	edsv3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	ldsv3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	rdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// server is the connection to one of the management servers of a client.
+type server struct {
+	uri        string
+	conn       *grpc.ClientConn
+	adsClient  xdsv3.AggregatedDiscoveryServiceClient
+	rdsClient  rdsv3.RouteDiscoveryServiceClient
+	ldsClient  ldsv3.ListenerDiscoveryServiceClient
+	cdsClient  cdsv3.ClusterDiscoveryServiceClient
+	edsClient  edsv3.EndpointDiscoveryServiceClient
+	srdsClient rdsv3.ScopedRoutesDiscoveryServiceClient
+	// vhdsClient only speaks the incremental protocol.
+	vhdsClient rdsv3.VirtualHostDiscoveryServiceClient
+}
+
+func dialServer(config ServerConfig) (*server, error) {
+	opts := []grpc.DialOption{config.Creds}
+	if config.CallCreds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(config.CallCreds))
+	}
+	conn, err := grpc.Dial(config.ServerURI, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial xds server %s: %w", config.ServerURI, err)
+	}
+	return &server{
+		uri:        config.ServerURI,
+		conn:       conn,
+		adsClient:  xdsv3.NewAggregatedDiscoveryServiceClient(conn),
+		rdsClient:  rdsv3.NewRouteDiscoveryServiceClient(conn),
+		ldsClient:  ldsv3.NewListenerDiscoveryServiceClient(conn),
+		cdsClient:  cdsv3.NewClusterDiscoveryServiceClient(conn),
+		edsClient:  edsv3.NewEndpointDiscoveryServiceClient(conn),
+		srdsClient: rdsv3.NewScopedRoutesDiscoveryServiceClient(conn),
+		vhdsClient: rdsv3.NewVirtualHostDiscoveryServiceClient(conn),
+	}, nil
+}
+
+// dialServers connects to the management server of config and to its
+// fallback servers, in order.
+func dialServers(config ServerConfig) ([]*server, error) {
+	configs := append([]ServerConfig{config}, config.FallbackServers...)
+	servers := make([]*server, 0, len(configs))
+	for _, config := range configs {
+		s, err := dialServer(config)
+		if err != nil {
+			closeServers(servers)
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+func closeServers(servers []*server) {
+	for _, s := range servers {
+		if err := s.conn.Close(); err != nil {
+			log.Warn().Err(err).Str("server", s.uri).Msg("failed to close xds connection")
+		}
+	}
+}
+
+// activeServer returns the index of the management server the streams
+// connect to.
+func (c *clientImpl) activeServer() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// failover moves the streams to the server following from, if from is still
+// the active one. Past the last fallback server, the primary is tried again.
+func (c *clientImpl) failover(from int) {
+	if len(c.servers) < 2 {
+		return
+	}
+	c.switchServer(from, (from+1)%len(c.servers))
+}
+
+// switchServer makes to the active server if from still is, and tears down
+// the streams of every other server so they reconnect to it. The resources
+// received so far stay in use, and are resubscribed to with their versions.
+func (c *clientImpl) switchServer(from, to int) {
+	c.mu.Lock()
+	if c.active != from || from == to || c.done.HasFired() {
+		c.mu.Unlock()
+		return
+	}
+	c.active = to
+	streams := make([]*stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.mu.Unlock()
+
+	log.Info().Str("server", c.servers[to].uri).Msg("switching xds server")
+	for _, s := range streams {
+		s.restart(to)
+	}
+}
+
+// watchPrimary switches back to the primary server whenever its connection
+// becomes ready while a fallback server is in use, until the client is
+// closed. The connection is kept trying meanwhile, gRPC only reconnecting
+// idle connections that have RPCs to carry.
+func (c *clientImpl) watchPrimary() {
+	conn := c.servers[0].conn
+	for {
+		state := conn.GetState()
+		if active := c.activeServer(); active != 0 {
+			switch state {
+			case connectivity.Idle:
+				conn.Connect()
+			case connectivity.Ready:
+				c.switchServer(active, 0)
+			}
+		}
+		if !conn.WaitForStateChange(c.ctx, state) {
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package xdsclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// fileStore keeps the last accepted resources of every type in a directory,
+// one file per type URL, so that a client restarted while the management
+// server is unreachable can serve them until it connects.
+type fileStore struct {
+	dir string
+	// maxStaleness is how old a file may be to be loaded, no limit if zero.
+	maxStaleness time.Duration
+}
+
+// path returns the file holding the resources of typeURL.
+func (s *fileStore) path(typeURL string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(typeURL, "/", "_")+".pb")
+}
+
+// save replaces the resources stored for typeURL by the received ones among
+// resources. The file is written to a temporary file first and renamed, so a
+// crash never leaves it half written.
+func (s *fileStore) save(typeURL string, resources map[string]resourcev3.UpdateWithMD) error {
+	resp := &xdsv3.DiscoveryResponse{TypeUrl: typeURL}
+	for name, r := range resources {
+		if r.Raw == nil {
+			continue
+		}
+		a, err := anypb.New(&xdsv3.Resource{
+			Name:     name,
+			Version:  r.MD.Version,
+			Resource: r.Raw,
+		})
+		if err != nil {
+			return err
+		}
+		resp.Resources = append(resp.Resources, a)
+	}
+	b, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(typeURL))
+}
+
+// load returns the resources stored for typeURL, as ACKed at the time they
+// were saved. It returns nothing if none were saved or if they are older than
+// maxStaleness.
+func (s *fileStore) load(typeURL string, now time.Time) (map[string]*resourcev3.UpdateWithMD, error) {
+	path := s.path(typeURL)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	savedAt := info.ModTime()
+	if s.maxStaleness > 0 && now.Sub(savedAt) > s.maxStaleness {
+		return nil, fmt.Errorf("stored resources saved at %s are too old", savedAt.Format(time.RFC3339))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	resp := &xdsv3.DiscoveryResponse{}
+	if err := proto.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	resources := make(map[string]*resourcev3.UpdateWithMD, len(resp.GetResources()))
+	for _, a := range resp.GetResources() {
+		r := &xdsv3.Resource{}
+		if err := a.UnmarshalTo(r); err != nil {
+			return nil, err
+		}
+		resources[r.GetName()] = &resourcev3.UpdateWithMD{
+			MD: resourcev3.UpdateMetadata{
+				Status:    resourcev3.ServiceStatusACKed,
+				Version:   r.GetVersion(),
+				Timestamp: savedAt,
+			},
+			Raw: r.GetResource(),
+		}
+	}
+	return resources, nil
+}
@@ -0,0 +1,57 @@
+package xdsclient
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+)
+
+func TestFileStore_SaveAndLoad_ShouldRestoreReceivedResources(t *testing.T) {
+	store := &fileStore{dir: t.TempDir()}
+	raw, err := anypb.New(&clusterv3.Cluster{Name: "cluster_0"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.save(version.V3ClusterURL, map[string]resourcev3.UpdateWithMD{
+		"cluster_0": {Raw: raw, MD: resourcev3.UpdateMetadata{Version: "1"}},
+		"cluster_1": {MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusRequested}},
+	}))
+	resources, err := store.load(version.V3ClusterURL, time.Now())
+
+	assert.NoError(t, err)
+	if assert.Len(t, resources, 1, "resources not received should not be stored") {
+		assert.Equal(t, resourcev3.ServiceStatusACKed, resources["cluster_0"].MD.Status)
+		assert.Equal(t, "1", resources["cluster_0"].MD.Version)
+		assert.Equal(t, version.V3ClusterURL, resources["cluster_0"].Raw.GetTypeUrl())
+	}
+	entries, err := os.ReadDir(store.dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files should be cleaned up")
+}
+
+func TestFileStore_NothingSaved_ShouldLoadNothing(t *testing.T) {
+	store := &fileStore{dir: t.TempDir()}
+
+	resources, err := store.load(version.V3ClusterURL, time.Now())
+
+	assert.NoError(t, err)
+	assert.Empty(t, resources)
+}
+
+func TestFileStore_TooOld_ShouldNotLoad(t *testing.T) {
+	store := &fileStore{dir: t.TempDir(), maxStaleness: time.Hour}
+	raw, err := anypb.New(&clusterv3.Cluster{Name: "cluster_0"})
+	assert.NoError(t, err)
+	assert.NoError(t, store.save(version.V3ClusterURL, map[string]resourcev3.UpdateWithMD{"cluster_0": {Raw: raw}}))
+
+	resources, err := store.load(version.V3ClusterURL, time.Now().Add(2*time.Hour))
+
+	assert.Error(t, err)
+	assert.Empty(t, resources)
+}
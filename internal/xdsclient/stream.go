@@ -0,0 +1,239 @@
+package xdsclient
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+const (
+	baseReconnectDelay = 1 * time.Second
+	maxReconnectDelay  = 2 * time.Minute
+)
+
+type streamClient interface {
+	Send(*xdsv3.DiscoveryRequest) error
+	Recv() (*xdsv3.DiscoveryResponse, error)
+	grpc.ClientStream
+}
+
+// stream is a single xDS stream to the management server. In ADS mode one
+// stream carries every resource type, otherwise each type has its own. The
+// underlying gRPC stream is recreated whenever it breaks.
+type stream struct {
+	client *clientImpl
+	// typeURL is the only type carried by the stream, or empty for ADS.
+	typeURL string
+
+	// sendMu serializes building and sending requests. Send is not safe to
+	// call from multiple goroutines, and incremental requests must reach the
+	// management server in the order their subscription diffs were taken.
+	// It also guards sc and dsc, at most one of which is set depending on
+	// whether the stream speaks the state-of-the-world or the incremental
+	// protocol. Both are nil while the stream is down.
+	sendMu sync.Mutex
+	sc     streamClient
+	dsc    deltaStreamClient
+	// nodeSent records whether the node identity went out on the current
+	// gRPC stream.
+	nodeSent bool
+	// server is the index of the management server of the current gRPC
+	// stream, and cancel tears that stream down.
+	server int
+	cancel context.CancelFunc
+}
+
+// sendSubscription tells the management server about the current
+// subscription of typeURL.
+func (s *stream) sendSubscription(typeURL string) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.sendLocked(typeURL, "", nil)
+}
+
+// sendAck ACKs the response with the given nonce, or NACKs it when nackErr is
+// not nil.
+func (s *stream) sendAck(typeURL, nonce string, nackErr error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.sendLocked(typeURL, nonce, nackErr)
+}
+
+// sendLocked sends a request on the current gRPC stream, if any. Requests
+// skipped while the stream is down are covered by the resubscription that
+// follows reconnecting. It must be called with s.sendMu held.
+func (s *stream) sendLocked(typeURL, nonce string, nackErr error) {
+	withNode := !s.nodeSent || !s.client.serverConfig.SetNodeOnFirstMessageOnly
+
+	var err error
+	switch {
+	case s.dsc != nil:
+		req := s.client.newDeltaRequest(typeURL, nonce, withNode, nackErr)
+		if req == nil {
+			return
+		}
+		err = s.dsc.Send(req)
+	case s.sc != nil:
+		req := s.client.newRequest(typeURL, withNode, nackErr)
+		if req == nil {
+			return
+		}
+		err = s.sc.Send(req)
+	default:
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("type", typeURL).Msg("failed to send discovery request")
+		return
+	}
+	s.nodeSent = true
+}
+
+// run keeps a gRPC stream open until the client is closed, waiting a jittered
+// exponential backoff between attempts. Attempts are counted from the last
+// stream that received a response.
+func (s *stream) run() {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(reconnectDelay(attempt)):
+			case <-s.client.done.Done():
+				return
+			}
+		}
+
+		srv, err := s.open()
+		if err != nil {
+			log.Warn().Err(err).Int("attempt", attempt).Msg("failed to open xds stream")
+			s.client.failover(srv)
+			continue
+		}
+
+		received, err := s.recv()
+		s.setClients(nil, nil)
+		if s.client.done.HasFired() {
+			return
+		}
+		if s.client.activeServer() != srv {
+			// Torn down to move to another server, which is connected to
+			// right away.
+			attempt = -1
+			continue
+		}
+		log.Warn().Err(err).Msg("xds stream failed")
+		s.client.handleStreamError(s.typeURL, err)
+		if received {
+			attempt = 0
+		} else {
+			s.client.failover(srv)
+		}
+	}
+}
+
+// open opens a new gRPC stream to the active management server and resends
+// every current subscription on it, along with the last accepted versions. It
+// returns the index of the server.
+func (s *stream) open() (int, error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.nodeSent = false
+	s.server = s.client.activeServer()
+	ctx, cancel := context.WithCancel(s.client.ctx)
+	s.cancel = cancel
+	var err error
+	if s.client.serverConfig.Delta {
+		s.dsc, err = s.client.newDeltaStreamClient(ctx, s.server, s.typeURL)
+	} else {
+		s.sc, err = s.client.newStreamClient(ctx, s.server, s.typeURL)
+	}
+	if err != nil {
+		cancel()
+		return s.server, err
+	}
+
+	for _, typeURL := range s.client.typeURLs(s.typeURL) {
+		s.client.resetStreamState(typeURL)
+		s.sendLocked(typeURL, "", nil)
+	}
+	return s.server, nil
+}
+
+// setClients replaces the gRPC stream, tearing the current one down when it
+// is cleared.
+func (s *stream) setClients(sc streamClient, dsc deltaStreamClient) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.sc, s.dsc = sc, dsc
+	if sc == nil && dsc == nil && s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// restart tears down the current gRPC stream unless it is connected to the
+// management server of index srv, so that run reconnects to it.
+func (s *stream) restart(srv int) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if (s.sc != nil || s.dsc != nil) && s.server != srv {
+		s.cancel()
+	}
+}
+
+// recv handles responses until the gRPC stream breaks. It reports whether any
+// response was received.
+func (s *stream) recv() (bool, error) {
+	for received := false; ; received = true {
+		typeURL, nonce, nackErr, err := s.recvResponse()
+		if err != nil {
+			return received, err
+		}
+
+		if nackErr != nil {
+			log.Warn().Err(nackErr).Str("type", typeURL).Msg("NACKing xds response")
+		}
+		s.sendAck(typeURL, nonce, nackErr)
+	}
+}
+
+// recvResponse receives and handles the next response, returning what is
+// needed to ACK or NACK it.
+func (s *stream) recvResponse() (typeURL, nonce string, nackErr error, err error) {
+	s.sendMu.Lock()
+	sc, dsc := s.sc, s.dsc
+	s.sendMu.Unlock()
+
+	if dsc != nil {
+		resp, err := dsc.Recv()
+		if err != nil {
+			return "", "", nil, err
+		}
+		return resp.GetTypeUrl(), resp.GetNonce(), s.client.handleDeltaResponse(resp), nil
+	}
+
+	resp, err := sc.Recv()
+	if err != nil {
+		return "", "", nil, err
+	}
+	return resp.GetTypeUrl(), resp.GetNonce(), s.client.handleResponse(resp), nil
+}
+
+// reconnectDelay returns how long to wait before the given reconnection
+// attempt. The delay doubles with every attempt up to maxReconnectDelay, and
+// is randomized by up to half its value so that clients restarted together do
+// not reconnect in lockstep.
+func reconnectDelay(attempt int) time.Duration {
+	delay := maxReconnectDelay
+	if attempt < 32 {
+		if d := baseReconnectDelay << (attempt - 1); d > 0 && d < maxReconnectDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
@@ -0,0 +1,360 @@
+package xdsclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	xdsv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource/version"
+	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/mockserver"
+)
+
+const testNodeID = "testNode"
+
+func TestReconnectDelay_ShouldGrowExponentiallyWithinBounds(t *testing.T) {
+	for attempt := 1; attempt < 100; attempt++ {
+		delay := reconnectDelay(attempt)
+
+		expected := maxReconnectDelay
+		if attempt < 8 {
+			expected = baseReconnectDelay << (attempt - 1)
+		}
+		assert.GreaterOrEqual(t, delay, expected/2, "attempt %d", attempt)
+		assert.Less(t, delay, expected, "attempt %d", attempt)
+	}
+}
+
+func TestStream_AcceptedResponse_ShouldAckVersionAndNonce(t *testing.T) {
+	server, _ := startMockServer(t, 18014, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18014, ServerConfig{})
+
+	c.WatchResource(resourcev3.ClusterType, "cluster_0", &recordingWatcher{})
+
+	ack := waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && req.GetResponseNonce() != ""
+	})
+	assert.Equal(t, "1", ack.GetVersionInfo())
+	assert.Nil(t, ack.GetErrorDetail())
+	assert.Equal(t, []string{"cluster_0"}, ack.GetResourceNames())
+	assert.Equal(t, testNodeID, ack.GetNode().GetId(), "node should be sent on every request by default")
+	first := server.Requests()[0]
+	assert.Empty(t, first.GetVersionInfo(), "first request should not carry a version")
+	assert.Empty(t, first.GetResponseNonce(), "first request should not carry a nonce")
+}
+
+func TestStream_RejectedResponse_ShouldNackWithPreviousVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := startMockServer(t, 18015, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18015, ServerConfig{})
+
+	c.WatchRouteConfig("route_config_0", func([]*routev3.RouteConfiguration, error) {})
+	ack := waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3RouteConfigURL && req.GetVersionInfo() == "1"
+	})
+
+	invalid := mockConfig("2", "test")
+	invalid.Listeners[0].RouteConfig.VirtualHosts[0].Domains = nil
+	server.SetConfig(ctx, invalid)
+
+	nack := waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3RouteConfigURL && req.GetErrorDetail() != nil
+	})
+	assert.Equal(t, "1", nack.GetVersionInfo(), "NACK should carry the last accepted version")
+	assert.NotEmpty(t, nack.GetResponseNonce())
+	assert.NotEqual(t, ack.GetResponseNonce(), nack.GetResponseNonce(), "NACK should carry the nonce of the rejected response")
+	assert.Contains(t, nack.GetErrorDetail().GetMessage(), "no domains")
+
+	md := c.DumpResources()[version.V3RouteConfigURL]["route_config_0"].MD
+	assert.Equal(t, resourcev3.ServiceStatusNACKed, md.Status)
+	assert.Equal(t, "1", md.Version)
+	if assert.NotNil(t, md.ErrState) {
+		assert.Equal(t, "2", md.ErrState.Version)
+	}
+}
+
+func TestStream_Reconnect_ShouldResendVersionWithoutNonce(t *testing.T) {
+	server, stop := startMockServer(t, 18016, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18016, ServerConfig{})
+
+	c.WatchResource(resourcev3.ClusterType, "cluster_0", &recordingWatcher{})
+	waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && req.GetVersionInfo() == "1"
+	})
+
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	restarted, _ := startMockServer(t, 18016, mockConfig("2", "test"))
+
+	resubscription := waitForRequest(t, restarted, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL
+	})
+	assert.Equal(t, "1", resubscription.GetVersionInfo(), "last accepted version should be resent")
+	assert.Empty(t, resubscription.GetResponseNonce(), "nonce of the previous stream should not be resent")
+}
+
+func TestStream_LastWatchCancelled_ShouldUnsubscribeWithEmptyNames(t *testing.T) {
+	server, _ := startMockServer(t, 18023, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18023, ServerConfig{})
+
+	cancel := c.WatchResource(resourcev3.ClusterType, "cluster_0", &recordingWatcher{})
+	waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && req.GetVersionInfo() == "1"
+	})
+	cancel()
+
+	unsubscription := waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && len(req.GetResourceNames()) == 0
+	})
+	assert.Equal(t, "1", unsubscription.GetVersionInfo())
+	assert.NotEmpty(t, unsubscription.GetResponseNonce())
+}
+
+func TestStream_DefaultTransportMode_ShouldMultiplexTypesOnOneADSStream(t *testing.T) {
+	server, _ := startMockServer(t, 18017, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18017, ServerConfig{})
+
+	watchMockConfig(c)
+
+	for _, typeURL := range []string{version.V3ListenerURL, version.V3RouteConfigURL, version.V3ClusterURL} {
+		waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+			return req.GetTypeUrl() == typeURL && req.GetVersionInfo() == "1"
+		})
+	}
+	assert.Equal(t, []string{""}, server.Streams(), "every type should share a single ADS stream")
+}
+
+func TestStream_TransportModeSeparate_ShouldOpenOneStreamPerType(t *testing.T) {
+	server, _ := startMockServer(t, 18018, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18018, ServerConfig{TransportMode: TransportModeSeparate})
+
+	watchMockConfig(c)
+
+	for _, typeURL := range []string{version.V3ListenerURL, version.V3RouteConfigURL, version.V3ClusterURL} {
+		waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+			return req.GetTypeUrl() == typeURL && req.GetVersionInfo() == "1"
+		})
+	}
+	assert.ElementsMatch(t, []string{version.V3ListenerURL, version.V3RouteConfigURL, version.V3ClusterURL}, server.Streams())
+}
+
+func TestDeltaStream_SubscriptionChanges_ShouldSendOnlyDiffs(t *testing.T) {
+	server, _ := startMockServer(t, 18019, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18019, ServerConfig{Delta: true})
+
+	cancel := c.WatchResource(resourcev3.ClusterType, "cluster_0", &recordingWatcher{})
+	waitForDeltaRequest(t, server, func(req *xdsv3.DeltaDiscoveryRequest) bool {
+		return req.GetResponseNonce() != ""
+	})
+	c.WatchResource(resourcev3.ClusterType, "cluster_1", &recordingWatcher{})
+	cancel()
+
+	waitForDeltaRequest(t, server, func(req *xdsv3.DeltaDiscoveryRequest) bool {
+		return len(req.GetResourceNamesUnsubscribe()) > 0
+	})
+	var subscribed, unsubscribed [][]string
+	for _, req := range server.DeltaRequests() {
+		if req.GetResponseNonce() == "" {
+			subscribed = append(subscribed, req.GetResourceNamesSubscribe())
+			unsubscribed = append(unsubscribed, req.GetResourceNamesUnsubscribe())
+		}
+	}
+	assert.Equal(t, [][]string{{"cluster_0"}, {"cluster_1"}, nil}, subscribed)
+	assert.Equal(t, [][]string{nil, nil, {"cluster_0"}}, unsubscribed)
+}
+
+func TestDeltaStream_Reconnect_ShouldSendInitialResourceVersions(t *testing.T) {
+	server, stop := startMockServer(t, 18020, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18020, ServerConfig{Delta: true})
+
+	c.WatchResource(resourcev3.ClusterType, "cluster_0", &recordingWatcher{})
+	waitForDeltaRequest(t, server, func(req *xdsv3.DeltaDiscoveryRequest) bool {
+		return req.GetResponseNonce() != ""
+	})
+	accepted := c.DumpResources()[version.V3ClusterURL]["cluster_0"].MD.Version
+	assert.NotEmpty(t, accepted)
+	assert.Empty(t, server.DeltaRequests()[0].GetInitialResourceVersions(), "nothing should be known on the first stream")
+
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	restarted, _ := startMockServer(t, 18020, mockConfig("1", "test"))
+
+	resubscription := waitForDeltaRequest(t, restarted, func(req *xdsv3.DeltaDiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL
+	})
+	assert.Equal(t, map[string]string{"cluster_0": accepted}, resubscription.GetInitialResourceVersions())
+	assert.Equal(t, []string{"cluster_0"}, resubscription.GetResourceNamesSubscribe())
+	assert.Empty(t, resubscription.GetResponseNonce())
+}
+
+func TestDeltaStream_RemovedResources_ShouldReportRemoval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := startMockServer(t, 18021, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18021, ServerConfig{Delta: true})
+
+	wildcard, named := &recordingWatcher{}, &recordingWatcher{}
+	c.WatchResource(resourcev3.ClusterType, "", wildcard)
+	c.WatchResource(resourcev3.ClusterType, "cluster_0", named)
+	waitForDeltaRequest(t, server, func(req *xdsv3.DeltaDiscoveryRequest) bool {
+		return req.GetResponseNonce() != ""
+	})
+
+	renamed := mockConfig("2", "test")
+	renamed.Listeners[0].RouteConfig.VirtualHosts[0].Routes[0].Cluster.Name = "cluster_1"
+	server.SetConfig(ctx, renamed)
+
+	assert.Eventually(t, func() bool {
+		wildcard.mu.Lock()
+		defer wildcard.mu.Unlock()
+		return len(wildcard.removed) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	wildcard.mu.Lock()
+	assert.Equal(t, []string{"cluster_0"}, wildcard.removed)
+	wildcard.mu.Unlock()
+	named.mu.Lock()
+	assert.Equal(t, 1, named.notExist)
+	named.mu.Unlock()
+	assert.Equal(t, resourcev3.ServiceStatusNotExist, c.DumpResources()[version.V3ClusterURL]["cluster_0"].MD.Status)
+}
+
+func TestStream_SetNodeOnFirstMessageOnly_ShouldSendNodeOncePerStream(t *testing.T) {
+	server, stop := startMockServer(t, 18022, mockConfig("1", "test"))
+	c := newMockServerClient(t, 18022, ServerConfig{SetNodeOnFirstMessageOnly: true})
+
+	watchMockConfig(c)
+	waitForRequest(t, server, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && req.GetVersionInfo() == "1"
+	})
+	assertNodeOnFirstRequestOnly(t, server.Requests())
+
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	restarted, _ := startMockServer(t, 18022, mockConfig("1", "test"))
+
+	waitForRequest(t, restarted, func(req *xdsv3.DiscoveryRequest) bool {
+		return req.GetTypeUrl() == version.V3ClusterURL && req.GetResponseNonce() != ""
+	})
+	assertNodeOnFirstRequestOnly(t, restarted.Requests())
+}
+
+// assertNodeOnFirstRequestOnly checks that only the first of the requests of
+// a stream carries the node.
+func assertNodeOnFirstRequestOnly(t *testing.T, requests []*xdsv3.DiscoveryRequest) {
+	assert.Greater(t, len(requests), 1)
+	for i, req := range requests {
+		if i == 0 {
+			assert.Equal(t, testNodeID, req.GetNode().GetId(), "first request should carry the node")
+		} else {
+			assert.Nil(t, req.GetNode(), "request %d should not carry the node", i)
+		}
+	}
+}
+
+// watchMockConfig watches the resources of mockConfig.
+func watchMockConfig(c *clientImpl) {
+	c.WatchListener("listener_0", func([]*listenerv3.Listener, error) {})
+	c.WatchRouteConfig("route_config_0", func([]*routev3.RouteConfiguration, error) {})
+	c.WatchCluster("cluster_0", func([]*clusterv3.Cluster, error) {})
+}
+
+// mockConfig serves listener_0 routing domain to cluster_0 through
+// route_config_0.
+func mockConfig(version, domain string) mockserver.Config {
+	return mockserver.Config{
+		Version: version,
+		Listeners: []mockserver.Listener{{
+			Name:    "listener_0",
+			Address: "0.0.0.0",
+			Port:    18000,
+			RouteConfig: mockserver.RouteConfig{
+				Name: "route_config_0",
+				VirtualHosts: []mockserver.VirtualHost{{
+					Name:    "virtual_host_0",
+					Domains: []string{domain},
+					Routes: []mockserver.Route{{
+						Name:   "route_0",
+						Prefix: "/",
+						Cluster: mockserver.Cluster{
+							Name: "cluster_0",
+							Endpoints: []mockserver.Endpoint{{
+								UpstreamHost: "127.0.0.1",
+								UpstreamPort: 8080,
+							}},
+						},
+					}},
+				}},
+			},
+		}},
+	}
+}
+
+// startMockServer serves config on port until the returned func is called or
+// the test ends.
+func startMockServer(t *testing.T, port uint, config mockserver.Config) (*mockserver.MockServer, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	server := mockserver.New(ctx, testNodeID, port)
+	server.StartRunning(ctx)
+	server.SetConfig(ctx, config)
+	return server, cancel
+}
+
+// newMockServerClient returns a client of the mock server listening on port,
+// closed when the test ends.
+func newMockServerClient(t *testing.T, port uint, config ServerConfig) *clientImpl {
+	config.ServerURI = fmt.Sprintf("127.0.0.1:%d", port)
+	config.Creds = grpc.WithTransportCredentials(insecure.NewCredentials())
+	config.NodeId = testNodeID
+	c, err := newClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// waitForRequest returns the first request received by server that matches,
+// failing the test if none arrives in time.
+func waitForRequest(t *testing.T, server *mockserver.MockServer, match func(*xdsv3.DiscoveryRequest) bool) *xdsv3.DiscoveryRequest {
+	var found *xdsv3.DiscoveryRequest
+	if !assert.Eventually(t, func() bool {
+		for _, req := range server.Requests() {
+			if match(req) {
+				found = req
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond) {
+		t.FailNow()
+	}
+	return found
+}
+
+// waitForDeltaRequest is the incremental counterpart of waitForRequest.
+func waitForDeltaRequest(t *testing.T, server *mockserver.MockServer, match func(*xdsv3.DeltaDiscoveryRequest) bool) *xdsv3.DeltaDiscoveryRequest {
+	var found *xdsv3.DeltaDiscoveryRequest
+	if !assert.Eventually(t, func() bool {
+		for _, req := range server.DeltaRequests() {
+			if match(req) {
+				found = req
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond) {
+		t.FailNow()
+	}
+	return found
+}
@@ -0,0 +1,449 @@
+package xdsclient
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+// wildcardName subscribes to every resource of a type in the incremental
+// protocol once other names are subscribed as well.
+const wildcardName = "*"
+
+// watch is a single registration made through WatchResource, identified by
+// its address so that cancelling it removes exactly that watcher.
+type watch struct {
+	// name is the watched resource name, empty for every resource of the
+	// type.
+	name    string
+	watcher resourcev3.ResourceWatcher
+}
+
+// wants reports whether the watch is interested in the named resource.
+func (wt *watch) wants(name string) bool {
+	return wt.name == "" || wt.name == name
+}
+
+// watchState holds the subscribed resource names, the registered watches and
+// the protocol state of a single resource type.
+type watchState struct {
+	// wildcards counts the watches of every resource of the type and names
+	// counts the watches of each resource name.
+	wildcards int
+	names     map[string]int
+	watches   []*watch
+
+	// version is the version_info of the last accepted response and nonce is
+	// the nonce of the last response received, accepted or not. Both are
+	// echoed back in every request of the type.
+	version string
+	nonce   string
+	// resources holds the last accepted copy of every resource along with
+	// its update metadata, keyed by resource name.
+	resources map[string]*resourcev3.UpdateWithMD
+	// persisted holds the resources loaded from the store of a previous run.
+	// They move to resources once watched, and are dropped when the first
+	// response of the type arrives.
+	persisted map[string]*resourcev3.UpdateWithMD
+
+	// aliases holds, for the resources received on the incremental protocol
+	// with aliases, the other names they were subscribed to under and are
+	// stored under as well.
+	aliases map[string][]string
+
+	// unwatched holds the resources whose last watch was removed while
+	// nothing else of the type was watched. Management servers that remember
+	// the names a state-of-the-world stream ever asked for do not send them
+	// again to a new watch: they are kept until names are subscribed to
+	// again.
+	unwatched map[string]*resourcev3.UpdateWithMD
+
+	// subscribed holds the names the management server was told about on
+	// the incremental protocol. It is nil until the first request of the
+	// type is sent.
+	subscribed map[string]struct{}
+	// requested records whether a state-of-the-world request of the type
+	// went out on the current gRPC stream. Only the first request without
+	// names subscribes to every resource, later ones unsubscribe from all.
+	requested bool
+
+	// expiryTimers holds, for every watched name not received yet, the timer
+	// reporting it as not existing.
+	expiryTimers map[string]*time.Timer
+}
+
+func newWatchState() *watchState {
+	return &watchState{
+		names:        make(map[string]int),
+		resources:    make(map[string]*resourcev3.UpdateWithMD),
+		aliases:      make(map[string][]string),
+		expiryTimers: make(map[string]*time.Timer),
+	}
+}
+
+// addWatch registers wt and adds its name to the subscription, an empty name
+// subscribing to every resource of the type.
+func (w *watchState) addWatch(wt *watch) {
+	w.watches = append(w.watches, wt)
+	if wt.name == "" {
+		w.wildcards++
+		for name, r := range w.unwatched {
+			if _, ok := w.resources[name]; !ok {
+				w.resources[name] = r
+			}
+		}
+		w.unwatched = nil
+		return
+	}
+
+	w.names[wt.name]++
+	if r, ok := w.unwatched[wt.name]; ok {
+		if _, ok := w.resources[wt.name]; !ok {
+			w.resources[wt.name] = r
+		}
+		delete(w.unwatched, wt.name)
+	}
+	if _, ok := w.resources[wt.name]; !ok {
+		w.resources[wt.name] = &resourcev3.UpdateWithMD{
+			MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusRequested},
+		}
+	}
+}
+
+// removeWatch unregisters wt. It reports whether the subscription changed,
+// that is whether wt was the last watch of its name. Resources no longer
+// covered by any watch are forgotten.
+func (w *watchState) removeWatch(wt *watch) bool {
+	found := false
+	for i := range w.watches {
+		if w.watches[i] == wt {
+			w.watches = append(w.watches[:i], w.watches[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	if wt.name == "" {
+		w.wildcards--
+		if w.wildcards > 0 {
+			return false
+		}
+		for name := range w.resources {
+			if _, ok := w.names[name]; !ok {
+				w.forget(name)
+			}
+		}
+		return true
+	}
+
+	w.names[wt.name]--
+	if w.names[wt.name] > 0 {
+		return false
+	}
+	delete(w.names, wt.name)
+	w.stopExpiryTimer(wt.name)
+	if w.wildcards == 0 {
+		w.forget(wt.name)
+	}
+	return true
+}
+
+// forget drops a resource no longer watched, keeping it aside if nothing of
+// the type is watched anymore.
+func (w *watchState) forget(name string) {
+	if r := w.resources[name]; r != nil && r.Raw != nil && w.empty() {
+		if w.unwatched == nil {
+			w.unwatched = make(map[string]*resourcev3.UpdateWithMD)
+		}
+		w.unwatched[name] = r
+	}
+	delete(w.resources, name)
+}
+
+// empty reports whether nothing of the type is watched anymore.
+func (w *watchState) empty() bool {
+	return w.wildcards == 0 && len(w.names) == 0
+}
+
+// watched reports whether any watch is interested in the named resource.
+func (w *watchState) watched(name string) bool {
+	_, ok := w.names[name]
+	return ok || w.wildcards > 0
+}
+
+// watchList returns a copy of the registered watches so their watchers can be
+// invoked without holding the client lock.
+func (w *watchState) watchList() []*watch {
+	return append([]*watch{}, w.watches...)
+}
+
+// restore moves the persisted copy of the named resource, or of every
+// resource when name is empty, to the resources in use unless they have been
+// received since.
+func (w *watchState) restore(name string) {
+	for n, r := range w.persisted {
+		if name != "" && name != n {
+			continue
+		}
+		if current, ok := w.resources[n]; !ok || awaited(current) {
+			w.resources[n] = r
+		}
+		delete(w.persisted, n)
+	}
+}
+
+// received returns a copy of every resource received, to be stored.
+func (w *watchState) received() map[string]resourcev3.UpdateWithMD {
+	received := make(map[string]resourcev3.UpdateWithMD, len(w.resources))
+	for name, r := range w.resources {
+		if r.Raw != nil {
+			received[name] = *r
+		}
+	}
+	return received
+}
+
+// cached returns the last accepted copy of the named resource, or of every
+// resource when name is empty.
+func (w *watchState) cached(name string) []*any.Any {
+	var raws []*any.Any
+	for n, r := range w.resources {
+		if r.Raw != nil && (name == "" || name == n) {
+			raws = append(raws, r.Raw)
+		}
+	}
+	return raws
+}
+
+// resourceNames returns the names to put in a request. An empty list
+// subscribes to every resource of the type.
+func (w *watchState) resourceNames() []string {
+	if w.wildcards > 0 {
+		return nil
+	}
+	names := make([]string, 0, len(w.names))
+	for k := range w.names {
+		names = append(names, k)
+	}
+	return names
+}
+
+// subscriptionDiff returns the names to subscribe to and unsubscribe from so
+// that the management server's view matches the current subscription, and
+// records that view as sent.
+func (w *watchState) subscriptionDiff() (subscribe, unsubscribe []string) {
+	wanted := make(map[string]struct{}, len(w.names)+1)
+	for name := range w.names {
+		wanted[name] = struct{}{}
+	}
+	// An empty first request is a wildcard subscription on its own, the
+	// explicit wildcard name is only needed alongside other names.
+	if w.wildcards > 0 && (w.subscribed != nil || len(w.names) > 0) {
+		wanted[wildcardName] = struct{}{}
+	}
+
+	for name := range wanted {
+		if _, ok := w.subscribed[name]; !ok {
+			subscribe = append(subscribe, name)
+		}
+	}
+	for name := range w.subscribed {
+		if _, ok := wanted[name]; !ok {
+			unsubscribe = append(unsubscribe, name)
+		}
+	}
+	w.subscribed = wanted
+	return subscribe, unsubscribe
+}
+
+// resourceVersions returns the version of every accepted resource, as sent in
+// the initial_resource_versions of a new incremental stream.
+func (w *watchState) resourceVersions() map[string]string {
+	aliases := make(map[string]struct{})
+	for _, names := range w.aliases {
+		for _, alias := range names {
+			aliases[alias] = struct{}{}
+		}
+	}
+	versions := make(map[string]string, len(w.resources))
+	for name, r := range w.resources {
+		if _, ok := aliases[name]; !ok && r.Raw != nil {
+			versions[name] = r.MD.Version
+		}
+	}
+	return versions
+}
+
+// startExpiryTimer arranges for expire to be called if name is still not
+// received after timeout.
+func (w *watchState) startExpiryTimer(name string, timeout time.Duration, expire func()) {
+	if _, ok := w.expiryTimers[name]; ok {
+		return
+	}
+	if r, ok := w.resources[name]; ok && !awaited(r) {
+		return
+	}
+	w.expiryTimers[name] = time.AfterFunc(timeout, expire)
+}
+
+// awaited reports whether r was requested but neither received nor known not
+// to exist.
+func awaited(r *resourcev3.UpdateWithMD) bool {
+	return r.Raw == nil && r.MD.Status != resourcev3.ServiceStatusNotExist
+}
+
+func (w *watchState) stopExpiryTimer(name string) {
+	if t, ok := w.expiryTimers[name]; ok {
+		t.Stop()
+		delete(w.expiryTimers, name)
+	}
+}
+
+// stopExpiryTimers stops every pending expiry timer.
+func (w *watchState) stopExpiryTimers() {
+	for name := range w.expiryTimers {
+		w.stopExpiryTimer(name)
+	}
+}
+
+// expire records that name was not received in time. It reports whether
+// name is still watched and missing, in which case its watchers have to be
+// told.
+func (w *watchState) expire(name string, now time.Time) bool {
+	if _, ok := w.expiryTimers[name]; !ok {
+		return false
+	}
+	delete(w.expiryTimers, name)
+	if r, ok := w.resources[name]; !ok || !awaited(r) {
+		return false
+	}
+	w.remove(name, now)
+	return true
+}
+
+// accept records a resource of an accepted response.
+func (w *watchState) accept(name, version string, raw *any.Any, now time.Time) {
+	w.stopExpiryTimer(name)
+	w.resources[name] = &resourcev3.UpdateWithMD{
+		MD: resourcev3.UpdateMetadata{
+			Status:    resourcev3.ServiceStatusACKed,
+			Version:   version,
+			Timestamp: now,
+		},
+		Raw: raw,
+	}
+}
+
+// acceptAliased records a resource of an accepted incremental response under
+// its name and every alias it was ever sent with: later updates of a resource
+// do not have to repeat them. It returns those aliases.
+func (w *watchState) acceptAliased(name string, aliases []string, version string, raw *any.Any, now time.Time) []string {
+	for _, alias := range aliases {
+		if alias != name && !contains(w.aliases[name], alias) {
+			w.aliases[name] = append(w.aliases[name], alias)
+		}
+	}
+
+	w.accept(name, version, raw, now)
+	for _, alias := range w.aliases[name] {
+		// Aliases no longer watched are not kept.
+		if _, ok := w.names[alias]; ok || w.wildcards > 0 {
+			w.accept(alias, version, raw, now)
+		}
+	}
+	return w.aliases[name]
+}
+
+// removeAliased records that the management server no longer has a resource
+// of the incremental protocol. It returns the names it was removed under,
+// its aliases included.
+func (w *watchState) removeAliased(name string, now time.Time) []string {
+	names := append([]string{name}, w.aliases[name]...)
+	delete(w.aliases, name)
+	for _, n := range names {
+		w.remove(n, now)
+	}
+	return names
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// remove records that the management server no longer has a resource.
+func (w *watchState) remove(name string, now time.Time) {
+	if _, ok := w.names[name]; !ok {
+		delete(w.resources, name)
+		return
+	}
+	w.resources[name] = &resourcev3.UpdateWithMD{
+		MD: resourcev3.UpdateMetadata{
+			Status:    resourcev3.ServiceStatusNotExist,
+			Timestamp: now,
+		},
+	}
+}
+
+// removeMissing removes every previously received resource that is not in
+// received, for the types whose state-of-the-world responses always carry
+// every resource. It returns the names removed.
+func (w *watchState) removeMissing(received map[string]struct{}, now time.Time) []string {
+	var removed []string
+	for name, r := range w.resources {
+		if _, ok := received[name]; ok || r.Raw == nil {
+			continue
+		}
+		w.remove(name, now)
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// nack records a rejected response against the named resources it carried,
+// the last accepted copy of which stays in use. Resources it did not carry
+// keep their status.
+func (w *watchState) nack(names []string, version string, err error, now time.Time) {
+	for _, name := range names {
+		r, ok := w.resources[name]
+		if !ok {
+			if w.wildcards == 0 {
+				continue
+			}
+			r = &resourcev3.UpdateWithMD{}
+			w.resources[name] = r
+		}
+		r.MD.Status = resourcev3.ServiceStatusNACKed
+		r.MD.ErrState = &resourcev3.UpdateErrorMetadata{
+			Version:   version,
+			Err:       err,
+			Timestamp: now,
+		}
+	}
+}
+
+// clearNacks forgets the rejections of every resource. A state-of-the-world
+// response is versioned as a whole, so accepting one supersedes every
+// version rejected before it.
+func (w *watchState) clearNacks() {
+	for _, r := range w.resources {
+		if r.MD.ErrState == nil {
+			continue
+		}
+		r.MD.ErrState = nil
+		if r.Raw != nil {
+			r.MD.Status = resourcev3.ServiceStatusACKed
+		} else {
+			r.MD.Status = resourcev3.ServiceStatusRequested
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package xdsclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+
+	resourcev3 "github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient/resource"
+)
+
+func TestSubscriptionDiff_WildcardOnly_ShouldSendEmptyFirstRequest(t *testing.T) {
+	ws := newWatchState()
+	ws.addWatch(&watch{})
+
+	subscribe, unsubscribe := ws.subscriptionDiff()
+
+	assert.Empty(t, subscribe)
+	assert.Empty(t, unsubscribe)
+	assert.NotNil(t, ws.subscribed)
+}
+
+func TestSubscriptionDiff_NewName_ShouldSubscribeOnlyNewName(t *testing.T) {
+	ws := newWatchState()
+	ws.addWatch(&watch{name: "a"})
+	ws.subscriptionDiff()
+
+	ws.addWatch(&watch{name: "b"})
+	subscribe, unsubscribe := ws.subscriptionDiff()
+
+	assert.Equal(t, []string{"b"}, subscribe)
+	assert.Empty(t, unsubscribe)
+}
+
+func TestSubscriptionDiff_DroppedName_ShouldUnsubscribe(t *testing.T) {
+	ws := newWatchState()
+	a := &watch{name: "a"}
+	ws.addWatch(a)
+	ws.addWatch(&watch{name: "b"})
+	ws.subscriptionDiff()
+
+	ws.removeWatch(a)
+	subscribe, unsubscribe := ws.subscriptionDiff()
+
+	assert.Empty(t, subscribe)
+	assert.Equal(t, []string{"a"}, unsubscribe)
+}
+
+func TestRemoveWatch_NameStillWatched_ShouldKeepSubscription(t *testing.T) {
+	ws := newWatchState()
+	first, second := &watch{name: "a"}, &watch{name: "a"}
+	ws.addWatch(first)
+	ws.addWatch(second)
+
+	assert.False(t, ws.removeWatch(first))
+	assert.Equal(t, []string{"a"}, ws.resourceNames())
+	assert.Len(t, ws.watchList(), 1)
+
+	assert.True(t, ws.removeWatch(second))
+	assert.True(t, ws.empty())
+	assert.NotContains(t, ws.resources, "a")
+}
+
+func TestRemoveWatch_Twice_ShouldBeNoop(t *testing.T) {
+	ws := newWatchState()
+	wt := &watch{name: "a"}
+	ws.addWatch(wt)
+
+	assert.True(t, ws.removeWatch(wt))
+	assert.False(t, ws.removeWatch(wt))
+	assert.Empty(t, ws.names)
+}
+
+func TestRemoveWatch_LastWatchReadded_ShouldKeepReceivedResource(t *testing.T) {
+	ws := newWatchState()
+	wt := &watch{name: "a"}
+	ws.addWatch(wt)
+	ws.accept("a", "1", &any.Any{}, time.Now())
+
+	ws.removeWatch(wt)
+	assert.NotContains(t, ws.resources, "a")
+	ws.addWatch(&watch{name: "a"})
+
+	assert.Len(t, ws.cached("a"), 1, "the server was never told about the unsubscription and will not send the resource again")
+}
+
+func TestRestore_NamedWatch_ShouldRestoreOnlyWatchedResource(t *testing.T) {
+	ws := newWatchState()
+	ws.persisted = map[string]*resourcev3.UpdateWithMD{
+		"a": {Raw: &any.Any{}, MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusACKed}},
+		"b": {Raw: &any.Any{}, MD: resourcev3.UpdateMetadata{Status: resourcev3.ServiceStatusACKed}},
+	}
+
+	ws.restore("a")
+	ws.addWatch(&watch{name: "a"})
+
+	assert.Len(t, ws.cached("a"), 1)
+	assert.NotContains(t, ws.resources, "b")
+	assert.Contains(t, ws.persisted, "b")
+}
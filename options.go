@@ -0,0 +1,125 @@
+package gohttpxds
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdsclient"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Option configures the http.Client built by NewHttpClient,
+// NewHttpClientFromBootstrap, NewHttpClientFromFile, Register and
+// RegisterFromBootstrap.
+type Option func(*options)
+
+type options struct {
+	watchExpiryTimeout time.Duration
+	waitForReady       bool
+	persistDir         string
+	maxStaleness       time.Duration
+	fileReloadInterval time.Duration
+	fallbackServers    []xdsclient.ServerConfig
+	authorities        map[string]*xdsclient.Authority
+	callCreds          credentials.PerRPCCredentials
+	delta              bool
+}
+
+// WithWatchExpiryTimeout sets how long a watched listener, route, cluster or
+// endpoints resource may take to arrive before it is assumed not to exist and
+// requests needing it fail. It defaults to 15 seconds.
+func WithWatchExpiryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.watchExpiryTimeout = timeout
+	}
+}
+
+// WithWaitForReady makes requests to xds:// URLs wait, within the limits of
+// their context, until the xDS config is complete instead of failing with
+// 404 while the client warms up.
+func WithWaitForReady() Option {
+	return func(o *options) {
+		o.waitForReady = true
+	}
+}
+
+// WithPersistDir stores the last resources accepted from the management
+// server in dir, and loads them when the client starts. A client restarted
+// while the management server is unreachable then keeps routing with them
+// until fresh ones arrive.
+func WithPersistDir(dir string) Option {
+	return func(o *options) {
+		o.persistDir = dir
+	}
+}
+
+// WithMaxStaleness sets how old the resources stored by WithPersistDir may be
+// to be loaded at startup. There is no limit by default.
+func WithMaxStaleness(maxStaleness time.Duration) Option {
+	return func(o *options) {
+		o.maxStaleness = maxStaleness
+	}
+}
+
+// WithFileReload makes a client built by NewHttpClientFromFile check its file
+// for changes at the given interval, and route with the new content once it
+// changed. The file is only read once by default.
+func WithFileReload(interval time.Duration) Option {
+	return func(o *options) {
+		o.fileReloadInterval = interval
+	}
+}
+
+// WithFallbackServer adds a management server to fail over to while the
+// primary one, and the fallback servers added before it, are unreachable.
+// The client returns to the primary server once it is reachable again, and
+// keeps routing with the config it holds during the switch.
+func WithFallbackServer(serverURI string, creds grpc.DialOption) Option {
+	return func(o *options) {
+		o.fallbackServers = append(o.fallbackServers, xdsclient.ServerConfig{ServerURI: serverURI, Creds: creds})
+	}
+}
+
+// WithAuthority fetches the resources named
+// xdstp://<name>/<resource type>/<id>, as referenced by the listeners, routes
+// and clusters of other control planes, from the management server at
+// serverURI over a connection of its own.
+func WithAuthority(name string, serverURI string, creds grpc.DialOption) Option {
+	return func(o *options) {
+		if o.authorities == nil {
+			o.authorities = make(map[string]*xdsclient.Authority)
+		}
+		o.authorities[name] = &xdsclient.Authority{
+			ClientListenerResourceNameTemplate: fmt.Sprintf("xdstp://%s/envoy.config.listener.v3.Listener/%%s", name),
+			XDSServer:                          &xdsclient.ServerConfig{ServerURI: serverURI, Creds: creds},
+		}
+	}
+}
+
+// WithCallCredentials authenticates every request to the management servers,
+// fallback and authority ones included unless the bootstrap file sets call
+// credentials of their own, such as with TokenFileCredentials.
+func WithCallCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(o *options) {
+		o.callCreds = creds
+	}
+}
+
+// WithIncrementalProtocol talks to the management servers with the
+// incremental variant of xDS, where only the resources that changed are sent.
+// It is required to fetch virtual hosts on demand through VHDS.
+func WithIncrementalProtocol() Option {
+	return func(o *options) {
+		o.delta = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{watchExpiryTimeout: xdsclient.DefaultWatchExpiryTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
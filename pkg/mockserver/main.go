@@ -7,6 +7,7 @@ import (
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/test/v3"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -14,27 +15,29 @@ var (
 )
 
 type MockServer struct {
-	cache  cache.SnapshotCache
-	server server.Server
-	nodeID string
-	port   uint
+	cache    cache.SnapshotCache
+	server   server.Server
+	recorder *recorder
+	nodeID   string
+	port     uint
 }
 
 func New(ctx context.Context, nodeID string, port uint) *MockServer {
 	cache := cache.NewSnapshotCache(false, cache.IDHash{}, l)
-	callback := &test.Callbacks{Debug: l.Debug}
+	callback := &recorder{Callbacks: &test.Callbacks{Debug: l.Debug}}
 	srv := server.NewServer(ctx, cache, callback)
 
 	return &MockServer{
-		cache:  cache,
-		server: srv,
-		nodeID: nodeID,
-		port:   port,
+		cache:    cache,
+		server:   srv,
+		recorder: callback,
+		nodeID:   nodeID,
+		port:     port,
 	}
 }
 
 func (m *MockServer) StartRunning(ctx context.Context) {
-	go RunServer(ctx, m.server, m.port)
+	go RunServer(ctx, m.server, m.port, grpc.ChainStreamInterceptor(m.recorder.intercept, m.answerMissingVirtualHosts))
 }
 
 func (m *MockServer) SetConfig(ctx context.Context, config Config) {
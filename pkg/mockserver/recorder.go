@@ -0,0 +1,86 @@
+package mockserver
+
+import (
+	"context"
+	"sync"
+
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// recorder keeps every stream opened and request received by the server, so
+// that tests can check what clients send.
+type recorder struct {
+	*test.Callbacks
+
+	mu            sync.Mutex
+	streams       []string
+	requests      []*discoveryv3.DiscoveryRequest
+	deltaRequests []*discoveryv3.DeltaDiscoveryRequest
+}
+
+func (r *recorder) OnStreamOpen(ctx context.Context, id int64, typ string) error {
+	r.mu.Lock()
+	r.streams = append(r.streams, typ)
+	r.mu.Unlock()
+	return r.Callbacks.OnStreamOpen(ctx, id, typ)
+}
+
+func (r *recorder) OnDeltaStreamOpen(ctx context.Context, id int64, typ string) error {
+	r.mu.Lock()
+	r.streams = append(r.streams, typ)
+	r.mu.Unlock()
+	return r.Callbacks.OnDeltaStreamOpen(ctx, id, typ)
+}
+
+// intercept records every request received on ss as sent by the client,
+// before the server fills in the node of the stream.
+func (r *recorder) intercept(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &recordingStream{ServerStream: ss, recorder: r})
+}
+
+type recordingStream struct {
+	grpc.ServerStream
+	recorder *recorder
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	switch req := m.(type) {
+	case *discoveryv3.DiscoveryRequest:
+		s.recorder.requests = append(s.recorder.requests, proto.Clone(req).(*discoveryv3.DiscoveryRequest))
+	case *discoveryv3.DeltaDiscoveryRequest:
+		s.recorder.deltaRequests = append(s.recorder.deltaRequests, proto.Clone(req).(*discoveryv3.DeltaDiscoveryRequest))
+	}
+	return nil
+}
+
+// Streams returns the type URL of every stream opened so far, in order, an
+// empty one for every ADS stream.
+func (m *MockServer) Streams() []string {
+	m.recorder.mu.Lock()
+	defer m.recorder.mu.Unlock()
+	return append([]string{}, m.recorder.streams...)
+}
+
+// Requests returns every state-of-the-world request received so far, in
+// order.
+func (m *MockServer) Requests() []*discoveryv3.DiscoveryRequest {
+	m.recorder.mu.Lock()
+	defer m.recorder.mu.Unlock()
+	return append([]*discoveryv3.DiscoveryRequest{}, m.recorder.requests...)
+}
+
+// DeltaRequests returns every incremental request received so far, in order.
+func (m *MockServer) DeltaRequests() []*discoveryv3.DeltaDiscoveryRequest {
+	m.recorder.mu.Lock()
+	defer m.recorder.mu.Unlock()
+	return append([]*discoveryv3.DeltaDiscoveryRequest{}, m.recorder.deltaRequests...)
+}
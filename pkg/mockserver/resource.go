@@ -33,6 +33,8 @@ import (
 )
 
 type Config struct {
+	// Version is the version of the served snapshot, "1" when empty.
+	Version   string
 	Listeners []Listener
 }
 
@@ -41,11 +43,34 @@ type Listener struct {
 	Address     string
 	Port        uint32
 	RouteConfig RouteConfig
+	// ScopedRoutes, when set, routes requests through the scopes served by
+	// SRDS instead of RouteConfig.
+	ScopedRoutes *ScopedRoutes
+}
+
+type ScopedRoutes struct {
+	Name string
+	// Header holds the scope key of requests.
+	Header string
+	Scopes []Scope
+}
+
+type Scope struct {
+	Name        string
+	Key         string
+	RouteConfig RouteConfig
 }
 
 type RouteConfig struct {
 	Name         string
 	VirtualHosts []VirtualHost
+	// VHDS serves the virtual hosts on demand, one VirtualHost resource per
+	// domain named "<route config name>/<domain>", instead of inlining them
+	// in the route configuration.
+	VHDS bool
+	// Inline sends the route configuration inline in the listener instead of
+	// serving it through RDS.
+	Inline bool
 }
 
 type VirtualHost struct {
@@ -63,6 +88,9 @@ type Route struct {
 type Cluster struct {
 	Name      string
 	Endpoints []Endpoint
+	// UseEDS serves the endpoints as a separate ClusterLoadAssignment
+	// resource instead of inlining them in the cluster.
+	UseEDS bool
 }
 
 type Endpoint struct {
@@ -71,16 +99,32 @@ type Endpoint struct {
 }
 
 func makeCluster(c Cluster) *clusterv3.Cluster {
+	if c.UseEDS {
+		return &clusterv3.Cluster{
+			Name:                 c.Name,
+			ConnectTimeout:       durationpb.New(5 * time.Second),
+			ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+			LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
+			EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+				EdsConfig: makeConfigSource(),
+			},
+		}
+	}
+
 	return &clusterv3.Cluster{
 		Name:                 c.Name,
 		ConnectTimeout:       durationpb.New(5 * time.Second),
 		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_LOGICAL_DNS},
 		LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
-		LoadAssignment: &endpointv3.ClusterLoadAssignment{
-			ClusterName: c.Name,
-			Endpoints:   makeEndpoint(c.Endpoints),
-		},
-		DnsLookupFamily: clusterv3.Cluster_V4_ONLY,
+		LoadAssignment:       makeLoadAssignment(c),
+		DnsLookupFamily:      clusterv3.Cluster_V4_ONLY,
+	}
+}
+
+func makeLoadAssignment(c Cluster) *endpointv3.ClusterLoadAssignment {
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: c.Name,
+		Endpoints:   makeEndpoint(c.Endpoints),
 	}
 }
 
@@ -111,12 +155,36 @@ func makeEndpoint(endpoints []Endpoint) []*endpointv3.LocalityLbEndpoints {
 }
 
 func makeRoute(routeConfig RouteConfig) *routev3.RouteConfiguration {
+	if routeConfig.VHDS {
+		return &routev3.RouteConfiguration{
+			Name: routeConfig.Name,
+			Vhds: &routev3.Vhds{ConfigSource: makeConfigSource()},
+		}
+	}
 	return &routev3.RouteConfiguration{
 		Name:         routeConfig.Name,
 		VirtualHosts: makeVirtualHost(routeConfig.VirtualHosts),
 	}
 }
 
+// makeOnDemandVirtualHosts returns the VirtualHost resources of a route
+// configuration served through VHDS. They are named "<route config>/<domain>"
+// since the incremental responses of go-control-plane name resources after
+// their content.
+func makeOnDemandVirtualHosts(routeConfig RouteConfig) []types.Resource {
+	var result []types.Resource
+	for _, vh := range makeVirtualHost(routeConfig.VirtualHosts) {
+		for _, domain := range vh.Domains {
+			result = append(result, &routev3.VirtualHost{
+				Name:    routeConfig.Name + "/" + domain,
+				Domains: []string{domain},
+				Routes:  vh.Routes,
+			})
+		}
+	}
+	return result
+}
+
 func makeVirtualHost(virtualHosts []VirtualHost) []*routev3.VirtualHost {
 	var result []*routev3.VirtualHost
 	for _, vh := range virtualHosts {
@@ -155,7 +223,42 @@ func makeRoutes(routes []Route) []*routev3.Route {
 	return result
 }
 
-func makeHTTPListener(listenerName string, address string, port uint32, route string) *listenerv3.Listener {
+// makeScopedRoutes returns the route specifier of a listener fetching its
+// scopes through SRDS.
+func makeScopedRoutes(scopedRoutes *ScopedRoutes) *hcmv3.HttpConnectionManager_ScopedRoutes {
+	return &hcmv3.HttpConnectionManager_ScopedRoutes{
+		ScopedRoutes: &hcmv3.ScopedRoutes{
+			Name: scopedRoutes.Name,
+			ScopeKeyBuilder: &hcmv3.ScopedRoutes_ScopeKeyBuilder{
+				Fragments: []*hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder{{
+					Type: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_{
+						HeaderValueExtractor: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor{
+							Name: scopedRoutes.Header,
+						},
+					},
+				}},
+			},
+			RdsConfigSource: makeConfigSource(),
+			ConfigSpecifier: &hcmv3.ScopedRoutes_ScopedRds{
+				ScopedRds: &hcmv3.ScopedRds{ScopedRdsConfigSource: makeConfigSource()},
+			},
+		},
+	}
+}
+
+func makeScopedRouteConfig(scope Scope) *routev3.ScopedRouteConfiguration {
+	return &routev3.ScopedRouteConfiguration{
+		Name:                   scope.Name,
+		RouteConfigurationName: scope.RouteConfig.Name,
+		Key: &routev3.ScopedRouteConfiguration_Key{
+			Fragments: []*routev3.ScopedRouteConfiguration_Key_Fragment{{
+				Type: &routev3.ScopedRouteConfiguration_Key_Fragment_StringKey{StringKey: scope.Key},
+			}},
+		},
+	}
+}
+
+func makeHTTPListener(l Listener) *listenerv3.Listener {
 	routerConfig, _ := anypb.New(&routerv3.Router{})
 	// HTTP filter configuration
 	manager := &hcmv3.HttpConnectionManager{
@@ -164,7 +267,7 @@ func makeHTTPListener(listenerName string, address string, port uint32, route st
 		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{
 			Rds: &hcmv3.Rds{
 				ConfigSource:    makeConfigSource(),
-				RouteConfigName: route,
+				RouteConfigName: l.RouteConfig.Name,
 			},
 		},
 		HttpFilters: []*hcmv3.HttpFilter{{
@@ -172,20 +275,25 @@ func makeHTTPListener(listenerName string, address string, port uint32, route st
 			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: routerConfig},
 		}},
 	}
+	if l.ScopedRoutes != nil {
+		manager.RouteSpecifier = makeScopedRoutes(l.ScopedRoutes)
+	} else if l.RouteConfig.Inline {
+		manager.RouteSpecifier = &hcmv3.HttpConnectionManager_RouteConfig{RouteConfig: makeRoute(l.RouteConfig)}
+	}
 	pbst, err := anypb.New(manager)
 	if err != nil {
 		panic(err)
 	}
 
 	return &listenerv3.Listener{
-		Name: listenerName,
+		Name: l.Name,
 		Address: &corev3.Address{
 			Address: &corev3.Address_SocketAddress{
 				SocketAddress: &corev3.SocketAddress{
 					Protocol: corev3.SocketAddress_TCP,
-					Address:  address,
+					Address:  l.Address,
 					PortSpecifier: &corev3.SocketAddress_PortValue{
-						PortValue: port,
+						PortValue: l.Port,
 					},
 				},
 			},
@@ -223,23 +331,51 @@ func GenerateSnapshot(config Config) *cache.Snapshot {
 	var listeners []types.Resource
 	var clusters []types.Resource
 	var routes []types.Resource
+	var endpoints []types.Resource
+	var virtualHosts []types.Resource
+	var scopedRoutes []types.Resource
 
-	for _, l := range config.Listeners {
-		listeners = append(listeners, makeHTTPListener(l.Name, l.Address, l.Port, l.RouteConfig.Name))
-		routes = append(routes, makeRoute(l.RouteConfig))
+	addRouteConfig := func(routeConfig RouteConfig) {
+		if !routeConfig.Inline {
+			routes = append(routes, makeRoute(routeConfig))
+		}
+		if routeConfig.VHDS {
+			virtualHosts = append(virtualHosts, makeOnDemandVirtualHosts(routeConfig)...)
+		}
 
-		for _, vh := range l.RouteConfig.VirtualHosts {
+		for _, vh := range routeConfig.VirtualHosts {
 			for _, r := range vh.Routes {
 				clusters = append(clusters, makeCluster(r.Cluster))
+				if r.Cluster.UseEDS {
+					endpoints = append(endpoints, makeLoadAssignment(r.Cluster))
+				}
 			}
 		}
 	}
+	for _, l := range config.Listeners {
+		listeners = append(listeners, makeHTTPListener(l))
+		if l.ScopedRoutes == nil {
+			addRouteConfig(l.RouteConfig)
+			continue
+		}
+		for _, scope := range l.ScopedRoutes.Scopes {
+			scopedRoutes = append(scopedRoutes, makeScopedRouteConfig(scope))
+			addRouteConfig(scope.RouteConfig)
+		}
+	}
 
-	snap, _ := cache.NewSnapshot("1",
+	version := config.Version
+	if version == "" {
+		version = "1"
+	}
+	snap, _ := cache.NewSnapshot(version,
 		map[resource.Type][]types.Resource{
-			resource.ClusterType:  clusters,
-			resource.RouteType:    routes,
-			resource.ListenerType: listeners,
+			resource.ClusterType:     clusters,
+			resource.EndpointType:    endpoints,
+			resource.RouteType:       routes,
+			resource.ListenerType:    listeners,
+			resource.VirtualHostType: virtualHosts,
+			resource.ScopedRouteType: scopedRoutes,
 		},
 	)
 	return snap
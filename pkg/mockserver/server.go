@@ -46,13 +46,16 @@ func registerServer(grpcServer *grpc.Server, server server.Server) {
 	edsv3.RegisterEndpointDiscoveryServiceServer(grpcServer, server)
 	cdsv3.RegisterClusterDiscoveryServiceServer(grpcServer, server)
 	rdsv3.RegisterRouteDiscoveryServiceServer(grpcServer, server)
+	rdsv3.RegisterScopedRoutesDiscoveryServiceServer(grpcServer, server)
+	rdsv3.RegisterVirtualHostDiscoveryServiceServer(grpcServer, server)
 	ldsv3.RegisterListenerDiscoveryServiceServer(grpcServer, server)
 	sdsv3.RegisterSecretDiscoveryServiceServer(grpcServer, server)
 	rtdsv3.RegisterRuntimeDiscoveryServiceServer(grpcServer, server)
 }
 
-// RunServer starts an xDS server at the given port.
-func RunServer(ctx context.Context, srv server.Server, port uint) {
+// RunServer starts an xDS server at the given port, with opts on top of the
+// defaults. The server stops when ctx is done.
+func RunServer(ctx context.Context, srv server.Server, port uint, opts ...grpc.ServerOption) {
 	// gRPC golang library sets a very small upper bound for the number gRPC/h2
 	// streams over a single TCP connection. If a proxy multiplexes requests over
 	// a single connection to the management server, then it might lead to
@@ -69,6 +72,7 @@ func RunServer(ctx context.Context, srv server.Server, port uint) {
 			PermitWithoutStream: true,
 		}),
 	)
+	grpcOptions = append(grpcOptions, opts...)
 	grpcServer := grpc.NewServer(grpcOptions...)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -77,6 +81,10 @@ func RunServer(ctx context.Context, srv server.Server, port uint) {
 	}
 
 	registerServer(grpcServer, srv)
+	go func() {
+		<-ctx.Done()
+		grpcServer.Stop()
+	}()
 
 	log.Printf("management server listening on %d\n", port)
 	if err = grpcServer.Serve(lis); err != nil {
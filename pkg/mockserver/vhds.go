@@ -0,0 +1,78 @@
+package mockserver
+
+import (
+	"strconv"
+	"sync"
+
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+)
+
+// answerMissingVirtualHosts answers the on-demand lookups of virtual hosts the
+// snapshot does not have with a Resource without body, as VHDS servers do.
+// go-control-plane leaves them unanswered.
+func (m *MockServer) answerMissingVirtualHosts(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &vhdsStream{ServerStream: ss, server: m})
+}
+
+type vhdsStream struct {
+	grpc.ServerStream
+	server *MockServer
+
+	// sendMu serializes the not-found responses with the ones of the
+	// server, sent from another goroutine.
+	sendMu sync.Mutex
+	nonce  int
+}
+
+func (s *vhdsStream) SendMsg(m interface{}) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *vhdsStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	req, ok := m.(*discoveryv3.DeltaDiscoveryRequest)
+	if !ok || req.GetTypeUrl() != resource.VirtualHostType {
+		return nil
+	}
+	missing := s.server.missingVirtualHosts(req.GetResourceNamesSubscribe())
+	if len(missing) == 0 {
+		return nil
+	}
+
+	s.sendMu.Lock()
+	s.nonce++
+	resp := &discoveryv3.DeltaDiscoveryResponse{
+		TypeUrl: resource.VirtualHostType,
+		Nonce:   "not-found-" + strconv.Itoa(s.nonce),
+	}
+	s.sendMu.Unlock()
+	for _, name := range missing {
+		resp.Resources = append(resp.Resources, &discoveryv3.Resource{Name: name, Aliases: []string{name}})
+	}
+	return s.SendMsg(resp)
+}
+
+// missingVirtualHosts returns the names of the virtual hosts the current
+// snapshot does not have.
+func (m *MockServer) missingVirtualHosts(names []string) []string {
+	snapshot, err := m.cache.GetSnapshot(m.nodeID)
+	if err != nil {
+		return nil
+	}
+	virtualHosts := snapshot.GetResources(resource.VirtualHostType)
+
+	var missing []string
+	for _, name := range names {
+		if _, ok := virtualHosts[name]; !ok && name != "*" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
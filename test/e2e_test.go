@@ -2,13 +2,22 @@ package test
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/k3rn3l-p4n1c/gohttpxds"
+	"github.com/k3rn3l-p4n1c/gohttpxds/csds"
 	"github.com/k3rn3l-p4n1c/gohttpxds/pkg/mockserver"
 
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	statusv3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
@@ -40,6 +49,21 @@ func Test(t *testing.T) {
 							}},
 						},
 					}},
+				}, {
+					Name:    "virtual_host_1",
+					Domains: []string{"test-eds"},
+					Routes: []mockserver.Route{{
+						Name:   "route_1",
+						Prefix: "/",
+						Cluster: mockserver.Cluster{
+							Name: "cluster_1",
+							Endpoints: []mockserver.Endpoint{{
+								UpstreamHost: "jsonplaceholder.typicode.com",
+								UpstreamPort: 80,
+							}},
+							UseEDS: true,
+						},
+					}},
 				}},
 			},
 		}},
@@ -59,7 +83,9 @@ func Test(t *testing.T) {
 	}
 
 	mockServer.SetConfig(ctx, config)
-	time.Sleep(1 * time.Second)
+	readyCtx, readyCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readyCancel()
+	assert.NoError(t, gohttpxds.WaitForReady(readyCtx, http.DefaultClient))
 
 	if resp, err := http.Get("xds://test2/todos/1"); err != nil {
 		panic(err.Error())
@@ -73,4 +99,605 @@ func Test(t *testing.T) {
 		assert.Equal(t, 200, resp.StatusCode)
 	}
 
+	if resp, err := http.Get("xds://test-eds/todos/1"); err != nil {
+		panic(err.Error())
+	} else {
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+}
+
+func TestReconnect(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	mockServer := mockserver.New(serverCtx, nodeId, 18002)
+	mockServer.StartRunning(serverCtx)
+	mockServer.SetConfig(serverCtx, localConfig(t, "1", "before", upstream))
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18002", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	client.Timeout = 10 * time.Second
+
+	if resp, err := client.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+	stopServer()
+	time.Sleep(100 * time.Millisecond)
+
+	if resp, err := client.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "last received config should be served while the control plane is down")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	restartedServer := mockserver.New(ctx, nodeId, 18002)
+	restartedServer.StartRunning(ctx)
+	restartedServer.SetConfig(ctx, localConfig(t, "2", "after", upstream))
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://after/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond, "client should reconnect and receive the new config")
+}
+
+func TestClose(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18003)
+	mockServer.StartRunning(ctx)
+	mockServer.SetConfig(ctx, localConfig(t, "1", "before", upstream))
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18003", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://before/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond)
+
+	handler, err := gohttpxds.ConfigDumpHandler(client)
+	assert.NoError(t, err)
+	dump := httptest.NewRecorder()
+	handler.ServeHTTP(dump, httptest.NewRequest(http.MethodGet, "/config_dump", nil))
+	if assert.Equal(t, 200, dump.Code) {
+		assert.Regexp(t, `"name":\s*"listener_0"`, dump.Body.String())
+		assert.Regexp(t, `"client_status":\s*"ACKED"`, dump.Body.String())
+		assert.Regexp(t, `"version_info":\s*"[^"]+"`, dump.Body.String())
+	}
+
+	assert.NoError(t, gohttpxds.Close(client))
+	assert.NoError(t, gohttpxds.Close(client), "closing twice should be a no-op")
+	assert.Error(t, gohttpxds.Close(&http.Client{}))
+
+	mockServer.SetConfig(ctx, localConfig(t, "2", "after", upstream))
+	assert.Never(t, func() bool {
+		resp, err := client.Get("xds://after/")
+		return err == nil && resp.StatusCode == 200
+	}, time.Second, 100*time.Millisecond, "closed client should not receive updates")
+
+	if resp, err := client.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "closed client should keep the last received config")
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18004)
+	mockServer.StartRunning(ctx)
+	mockServer.SetConfig(ctx, localConfig(t, "1", "before", upstream))
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18004", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId)
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	readyCtx, readyCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readyCancel()
+	assert.NoError(t, gohttpxds.WaitForReady(readyCtx, client))
+
+	csdsServer, err := csds.NewServer(client)
+	assert.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	statusv3.RegisterClientStatusDiscoveryServiceServer(grpcServer, csdsServer)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	stream, err := statusv3.NewClientStatusDiscoveryServiceClient(conn).StreamClientStatus(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&statusv3.ClientStatusRequest{}))
+	resp, err := stream.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, resp.Config, 1) {
+		return
+	}
+
+	assert.Equal(t, nodeId, resp.Config[0].Node.GetId())
+	statuses := make(map[string]adminv3.ClientResourceStatus)
+	for _, config := range resp.Config[0].GenericXdsConfigs {
+		statuses[config.Name] = config.ClientStatus
+		assert.NotEmpty(t, config.VersionInfo)
+	}
+	assert.Equal(t, map[string]adminv3.ClientResourceStatus{
+		"listener_0":     adminv3.ClientResourceStatus_ACKED,
+		"route_config_0": adminv3.ClientResourceStatus_ACKED,
+		"cluster_0":      adminv3.ClientResourceStatus_ACKED,
+	}, statuses)
+}
+
+func TestPersist(t *testing.T) {
+	nodeId := "testNode"
+	dir := t.TempDir()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18005)
+	mockServer.StartRunning(ctx)
+	mockServer.SetConfig(ctx, localConfig(t, "1", "before", upstream))
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18005", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithPersistDir(dir))
+	assert.NoError(t, err)
+	readyCtx, readyCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readyCancel()
+	assert.NoError(t, gohttpxds.WaitForReady(readyCtx, client))
+	assert.NoError(t, gohttpxds.Close(client))
+
+	// Nothing listens on 18006: the restarted client only has what the first
+	// one stored.
+	restarted, err := gohttpxds.NewHttpClient("127.0.0.1:18006", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithPersistDir(dir))
+	assert.NoError(t, err)
+	defer gohttpxds.Close(restarted)
+	assert.NoError(t, gohttpxds.WaitForReady(readyCtx, restarted))
+	if resp, err := restarted.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "stored config should be served while the control plane is unreachable")
+	}
+
+	stale, err := gohttpxds.NewHttpClient("127.0.0.1:18006", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithPersistDir(dir), gohttpxds.WithMaxStaleness(time.Nanosecond))
+	assert.NoError(t, err)
+	defer gohttpxds.Close(stale)
+	if resp, err := stale.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode, "stored config older than the max staleness should not be used")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fallbackServer := mockserver.New(ctx, nodeId, 18008)
+	fallbackServer.StartRunning(ctx)
+	fallbackServer.SetConfig(ctx, localConfig(t, "1", "fallback", upstream))
+
+	// Nothing listens on 18007 until the primary server is started below.
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18007", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId,
+		gohttpxds.WithFallbackServer("127.0.0.1:18008", grpc.WithTransportCredentials(insecure.NewCredentials())),
+		gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 10 * time.Second
+
+	if resp, err := client.Get("xds://fallback/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "config of the fallback server should be used while the primary is down")
+	}
+
+	primaryCtx, stopPrimary := context.WithCancel(context.Background())
+	primaryServer := mockserver.New(primaryCtx, nodeId, 18007)
+	primaryServer.StartRunning(primaryCtx)
+	primaryServer.SetConfig(primaryCtx, localConfig(t, "2", "primary", upstream))
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://primary/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond, "client should return to the primary server once it is up")
+
+	stopPrimary()
+	if resp, err := client.Get("xds://primary/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "config should be kept while failing over")
+	}
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://fallback/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond, "client should fail over to the fallback server")
+}
+
+func TestFederation(t *testing.T) {
+	nodeId := "testNode"
+	clusterName := "xdstp://eu/envoy.config.cluster.v3.Cluster/cluster_0"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	wrongUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer wrongUpstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Both servers serve the cluster, only the one of the eu authority is
+	// asked for it.
+	mockServer := mockserver.New(ctx, nodeId, 18009)
+	mockServer.StartRunning(ctx)
+	config := localConfig(t, "1", "federated", wrongUpstream)
+	config.Listeners[0].RouteConfig.VirtualHosts[0].Routes[0].Cluster.Name = clusterName
+	mockServer.SetConfig(ctx, config)
+
+	euServer := mockserver.New(ctx, nodeId, 18010)
+	euServer.StartRunning(ctx)
+	euConfig := localConfig(t, "1", "federated", upstream)
+	euConfig.Listeners[0].RouteConfig.VirtualHosts[0].Routes[0].Cluster.Name = clusterName
+	euServer.SetConfig(ctx, euConfig)
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18009", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId,
+		gohttpxds.WithAuthority("eu", "127.0.0.1:18010", grpc.WithTransportCredentials(insecure.NewCredentials())),
+		gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 10 * time.Second
+
+	if resp, err := client.Get("xds://federated/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "cluster should be fetched from the server of its authority")
+	}
+}
+
+func TestVirtualHostDiscovery(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18011)
+	mockServer.StartRunning(ctx)
+	config := localConfig(t, "1", "on-demand", upstream)
+	config.Listeners[0].RouteConfig.VHDS = true
+	mockServer.SetConfig(ctx, config)
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18011", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId,
+		gohttpxds.WithWatchExpiryTimeout(500*time.Millisecond), gohttpxds.WithWaitForReady(), gohttpxds.WithIncrementalProtocol())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 10 * time.Second
+
+	if resp, err := client.Get("xds://on-demand/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "virtual host should be fetched on demand")
+	}
+	if resp, err := client.Get("xds://unknown/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode, "virtual host the server does not have should not match")
+	}
+}
+
+func TestVirtualHostNotFound(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18024)
+	mockServer.StartRunning(ctx)
+	config := localConfig(t, "1", "on-demand", upstream)
+	config.Listeners[0].RouteConfig.VHDS = true
+	mockServer.SetConfig(ctx, config)
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18024", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId,
+		gohttpxds.WithWatchExpiryTimeout(time.Minute), gohttpxds.WithWaitForReady(), gohttpxds.WithIncrementalProtocol())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	// Shorter than the watch expiry: only the answer of the server can
+	// tell the virtual host does not exist in time.
+	client.Timeout = 5 * time.Second
+
+	if resp, err := client.Get("xds://unknown/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode, "virtual host the server answered not found should not match")
+	}
+}
+
+func TestScopedRoutes(t *testing.T) {
+	nodeId := "testNode"
+
+	acmeUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer acmeUpstream.Close()
+	globexUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer globexUpstream.Close()
+	unscopedUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+	}))
+	defer unscopedUpstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18012)
+	mockServer.StartRunning(ctx)
+	acme := localConfig(t, "1", "scoped", acmeUpstream).Listeners[0].RouteConfig
+	acme.Name = "route_config_acme"
+	globex := localConfig(t, "1", "scoped", globexUpstream).Listeners[0].RouteConfig
+	globex.Name = "route_config_globex"
+	globex.VirtualHosts[0].Routes[0].Cluster.Name = "cluster_1"
+	config := localConfig(t, "1", "scoped", acmeUpstream)
+	config.Listeners[0].ScopedRoutes = &mockserver.ScopedRoutes{
+		Name:   "tenants",
+		Header: "x-tenant",
+		Scopes: []mockserver.Scope{
+			{Name: "acme", Key: "acme", RouteConfig: acme},
+			{Name: "globex", Key: "globex", RouteConfig: globex},
+		},
+	}
+	unscoped := localConfig(t, "1", "scoped", unscopedUpstream).Listeners[0]
+	unscoped.Name = "listener_1"
+	unscoped.RouteConfig.Name = "route_config_unscoped"
+	unscoped.RouteConfig.VirtualHosts[0].Routes[0].Cluster.Name = "cluster_2"
+	config.Listeners = append(config.Listeners, unscoped)
+	mockServer.SetConfig(ctx, config)
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18012", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 10 * time.Second
+
+	get := func(tenant string) int {
+		req, err := http.NewRequest(http.MethodGet, "xds://scoped/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tenant != "" {
+			req.Header.Set("x-tenant", tenant)
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		return resp.StatusCode
+	}
+	assert.Equal(t, 200, get("acme"), "route configuration of the acme scope should be used")
+	assert.Equal(t, 202, get("globex"), "route configuration of the globex scope should be used")
+	assert.Equal(t, 404, get("initech"), "requests of unknown scopes should not fall back to other route configurations")
+	assert.Equal(t, 404, get(""), "requests without scope key should not fall back to other route configurations")
+}
+
+func TestInlineRouteConfig(t *testing.T) {
+	nodeId := "testNode"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer := mockserver.New(ctx, nodeId, 18013)
+	mockServer.StartRunning(ctx)
+	config := localConfig(t, "1", "inline", upstream)
+	config.Listeners[0].RouteConfig.Inline = true
+	mockServer.SetConfig(ctx, config)
+
+	client, err := gohttpxds.NewHttpClient("127.0.0.1:18013", grpc.WithTransportCredentials(insecure.NewCredentials()), nodeId, gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 10 * time.Second
+
+	if resp, err := client.Get("xds://inline/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode, "inline route configuration should be used")
+	}
+
+	mockServer.SetConfig(ctx, localConfig(t, "2", "rds", upstream))
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://rds/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond, "listener should switch to RDS")
+	if resp, err := client.Get("xds://inline/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode, "inline route configuration should be dropped")
+	}
+
+	config = localConfig(t, "3", "inline-again", upstream)
+	config.Listeners[0].RouteConfig.Inline = true
+	mockServer.SetConfig(ctx, config)
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://inline-again/")
+		return err == nil && resp.StatusCode == 200
+	}, 10*time.Second, 100*time.Millisecond, "listener should switch back to an inline route configuration")
+	if resp, err := client.Get("xds://rds/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode, "route configuration fetched through RDS should be dropped")
+	}
+}
+
+func TestFile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	host, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "xds.yaml")
+	writeConfig := func(domain string) {
+		config := fmt.Sprintf(`
+static_resources:
+  listeners:
+  - name: listener_0
+    api_listener:
+      api_listener:
+        "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+        rds:
+          route_config_name: route_config_0
+  clusters:
+  - name: cluster_0
+    type: STATIC
+    load_assignment:
+      cluster_name: cluster_0
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: {address: %s, port_value: %s}
+resources:
+- "@type": type.googleapis.com/envoy.config.route.v3.RouteConfiguration
+  name: route_config_0
+  virtual_hosts:
+  - name: virtual_host_0
+    domains: [%s]
+    routes:
+    - match: {prefix: /}
+      route: {cluster: cluster_0}
+`, host, port, domain)
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("before")
+
+	client, err := gohttpxds.NewHttpClientFromFile(path, gohttpxds.WithFileReload(10*time.Millisecond), gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 5 * time.Second
+
+	if resp, err := client.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+	writeConfig("after")
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("xds://after/")
+		return err == nil && resp.StatusCode == 200
+	}, 5*time.Second, 10*time.Millisecond, "file changes should be reloaded")
+	if resp, err := client.Get("xds://before/"); assert.NoError(t, err) {
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+
+	_, err = gohttpxds.NewHttpClientFromFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestWeightedClusters(t *testing.T) {
+	unweighted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer unweighted.Close()
+	weighted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer weighted.Close()
+	endpoint := func(upstream *httptest.Server) (string, string) {
+		host, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return host, port
+	}
+	unweightedHost, unweightedPort := endpoint(unweighted)
+	weightedHost, weightedPort := endpoint(weighted)
+	path := filepath.Join(t.TempDir(), "xds.yaml")
+	config := fmt.Sprintf(`
+static_resources:
+  listeners:
+  - name: listener_0
+    api_listener:
+      api_listener:
+        "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+        route_config:
+          name: route_config_0
+          virtual_hosts:
+          - name: virtual_host_0
+            domains: [weighted]
+            routes:
+            - match: {prefix: /}
+              route:
+                weighted_clusters:
+                  clusters:
+                  - {name: cluster_0, weight: 0}
+                  - {name: cluster_1, weight: 1}
+  clusters:
+  - name: cluster_0
+    type: STATIC
+    load_assignment:
+      cluster_name: cluster_0
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: {address: %s, port_value: %s}
+  - name: cluster_1
+    type: STATIC
+    load_assignment:
+      cluster_name: cluster_1
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: {address: %s, port_value: %s}
+`, unweightedHost, unweightedPort, weightedHost, weightedPort)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := gohttpxds.NewHttpClientFromFile(path, gohttpxds.WithWaitForReady())
+	assert.NoError(t, err)
+	defer gohttpxds.Close(client)
+	client.Timeout = 5 * time.Second
+
+	for i := 0; i < 10; i++ {
+		if resp, err := client.Get("xds://weighted/"); assert.NoError(t, err) {
+			assert.Equal(t, 202, resp.StatusCode, "clusters without weight should never be picked")
+		}
+	}
+}
+
+// localConfig routes domain to the given local upstream.
+func localConfig(t *testing.T, version string, domain string, upstream *httptest.Server) mockserver.Config {
+	host, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mockserver.Config{
+		Version: version,
+		Listeners: []mockserver.Listener{{
+			Name:    "listener_0",
+			Address: "0.0.0.0",
+			Port:    18000,
+			RouteConfig: mockserver.RouteConfig{
+				Name: "route_config_0",
+				VirtualHosts: []mockserver.VirtualHost{{
+					Name:    "virtual_host_0",
+					Domains: []string{domain},
+					Routes: []mockserver.Route{{
+						Name:   "route_0",
+						Prefix: "/",
+						Cluster: mockserver.Cluster{
+							Name: "cluster_0",
+							Endpoints: []mockserver.Endpoint{{
+								UpstreamHost: host,
+								UpstreamPort: uint32(portNumber),
+							}},
+						},
+					}},
+				}},
+			},
+		}},
+	}
 }
@@ -1,22 +1,70 @@
 package transport
 
 import (
+	"fmt"
+	"math/rand"
+
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
 )
 
-func (w *Wrapper) getCluster(ra *routev3.RouteAction) ([]*clusterv3.Cluster, error) {
+// getCluster returns the cluster ra forwards requests to. Only clusters named
+// in the route action, directly or as weighted clusters, are supported, the
+// others fail the request.
+func getCluster(ra *routev3.RouteAction, snapshot *xdscache.Snapshot) (*clusterv3.Cluster, error) {
 	switch clusterSpecifier := ra.ClusterSpecifier.(type) {
 	case *routev3.RouteAction_Cluster:
 		name := clusterSpecifier.Cluster
-		return w.cache.GetCluster(name)
+		return snapshot.GetCluster(name)
 	case *routev3.RouteAction_ClusterHeader:
-		panic("not implemented")
+		return nil, fmt.Errorf("cluster_header is not supported")
 	case *routev3.RouteAction_WeightedClusters:
-		panic("not implemented")
+		name, err := chooseWeightedCluster(clusterSpecifier.WeightedClusters)
+		if err != nil {
+			return nil, err
+		}
+		return snapshot.GetCluster(name)
 	case *routev3.RouteAction_ClusterSpecifierPlugin:
-		panic("not implemented")
+		return nil, fmt.Errorf("cluster_specifier_plugin is not supported")
 	default:
-		panic("not implemented")
+		return nil, fmt.Errorf("route action has no cluster")
+	}
+}
+
+// chooseWeightedCluster picks one of the clusters of wc at random, in
+// proportion to their weights. Clusters without weight are never picked.
+func chooseWeightedCluster(wc *routev3.WeightedCluster) (string, error) {
+	var totalWeight uint64
+	for _, cluster := range wc.GetClusters() {
+		totalWeight += uint64(cluster.GetWeight().GetValue())
+	}
+	if totalWeight == 0 {
+		return "", fmt.Errorf("weighted_clusters has no cluster with weight")
 	}
+
+	target := uint64(rand.Int63n(int64(totalWeight)))
+	for _, cluster := range wc.GetClusters() {
+		weight := uint64(cluster.GetWeight().GetValue())
+		if target < weight {
+			if cluster.GetName() == "" {
+				return "", fmt.Errorf("cluster_header of weighted_clusters is not supported")
+			}
+			return cluster.GetName(), nil
+		}
+		target -= weight
+	}
+	return "", fmt.Errorf("weighted_clusters has no cluster with weight")
+}
+
+// getLoadAssignment returns the endpoints of cluster, resolved through EDS
+// when the cluster asks for it.
+func getLoadAssignment(cluster *clusterv3.Cluster, snapshot *xdscache.Snapshot) (*endpointv3.ClusterLoadAssignment, error) {
+	if cluster.GetType() != clusterv3.Cluster_EDS {
+		return cluster.LoadAssignment, nil
+	}
+
+	return snapshot.GetClusterLoadAssignment(xdscache.EDSServiceName(cluster))
 }
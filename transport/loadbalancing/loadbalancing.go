@@ -1,15 +1,33 @@
 package loadbalancing
 
 import (
+	"errors"
+	"sync"
+
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 )
 
+// ErrNoEndpoint is returned when a cluster has no endpoint to choose from.
+var ErrNoEndpoint = errors.New("no endpoint to choose from")
+
 type loadBalancer interface {
-	Choose([]*endpointv3.LbEndpoint) *endpointv3.LbEndpoint
+	Choose([]*endpointv3.LbEndpoint) (*endpointv3.LbEndpoint, error)
 }
 
-var loadBalancers map[string]loadBalancer
+// loadBalancerEntry is the balancer of a cluster along with what it was
+// created for, so that it starts over once the endpoint set is replaced.
+type loadBalancerEntry struct {
+	lb             loadBalancer
+	lbPolicy       clusterv3.Cluster_LbPolicy
+	loadAssignment *endpointv3.ClusterLoadAssignment
+}
+
+var (
+	// loadBalancersMu guards loadBalancers, shared by every request.
+	loadBalancersMu sync.Mutex
+	loadBalancers   map[string]loadBalancerEntry
+)
 
 var lbPolicyConstructors map[clusterv3.Cluster_LbPolicy]func() loadBalancer = map[clusterv3.Cluster_LbPolicy]func() loadBalancer{
 	clusterv3.Cluster_ROUND_ROBIN:                  func() loadBalancer { return &roundRobinLoadBalancer{} },
@@ -22,29 +40,48 @@ var lbPolicyConstructors map[clusterv3.Cluster_LbPolicy]func() loadBalancer = ma
 }
 
 func init() {
-	loadBalancers = make(map[string]loadBalancer)
+	loadBalancers = make(map[string]loadBalancerEntry)
 }
 
-func getOrCreateLoadBalancer(cluster *clusterv3.Cluster) loadBalancer {
-	// todo check equality of clusters
-	lb, found := loadBalancers[cluster.Name]
-	if found {
-		return lb
+// getOrCreateLoadBalancer returns the balancer of cluster. Resources in the
+// cache are immutable, so a new load assignment means the endpoint set was
+// replaced and the balancer is created anew.
+func getOrCreateLoadBalancer(cluster *clusterv3.Cluster, loadAssignment *endpointv3.ClusterLoadAssignment) loadBalancer {
+	loadBalancersMu.Lock()
+	defer loadBalancersMu.Unlock()
+
+	entry, found := loadBalancers[cluster.GetName()]
+	if found && entry.lbPolicy == cluster.GetLbPolicy() && entry.loadAssignment == loadAssignment {
+		return entry.lb
 	}
 
 	// todo implement other load balancers
-	lb = lbPolicyConstructors[cluster.LbPolicy]()
-	loadBalancers[cluster.Name] = lb
-	return lb
+	entry = loadBalancerEntry{
+		lb:             lbPolicyConstructors[cluster.GetLbPolicy()](),
+		lbPolicy:       cluster.GetLbPolicy(),
+		loadAssignment: loadAssignment,
+	}
+	loadBalancers[cluster.GetName()] = entry
+	return entry.lb
 }
 
-func ChooseEndpoint(cluster *clusterv3.Cluster) *endpointv3.Endpoint {
-	locality := chooseLocality(cluster.LoadAssignment.Endpoints)
-	lb := getOrCreateLoadBalancer(cluster)
-	return lb.Choose(locality.LbEndpoints).HostIdentifier.(*endpointv3.LbEndpoint_Endpoint).Endpoint
+// ChooseEndpoint picks an endpoint of cluster out of loadAssignment, which is
+// either inlined in the cluster or discovered through EDS. It returns
+// ErrNoEndpoint when there is no endpoint to choose from.
+func ChooseEndpoint(cluster *clusterv3.Cluster, loadAssignment *endpointv3.ClusterLoadAssignment) (*endpointv3.Endpoint, error) {
+	locality := chooseLocality(loadAssignment.GetEndpoints())
+	lb := getOrCreateLoadBalancer(cluster, loadAssignment)
+	lbEndpoint, err := lb.Choose(locality.GetLbEndpoints())
+	if err != nil {
+		return nil, err
+	}
+	return lbEndpoint.GetEndpoint(), nil
 }
 
 func chooseLocality(localityLbEndpoints []*endpointv3.LocalityLbEndpoints) *endpointv3.LocalityLbEndpoints {
 	// todo: not implemented
+	if len(localityLbEndpoints) == 0 {
+		return nil
+	}
 	return localityLbEndpoints[0]
 }
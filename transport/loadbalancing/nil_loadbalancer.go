@@ -11,10 +11,10 @@ type nilLoadBalancer struct {
 	mtx          sync.Mutex
 }
 
-func (lb *nilLoadBalancer) Choose(endpoints []*endpointv3.LbEndpoint) *endpointv3.LbEndpoint {
+func (lb *nilLoadBalancer) Choose(endpoints []*endpointv3.LbEndpoint) (*endpointv3.LbEndpoint, error) {
 	if len(endpoints) == 0 {
-		return nil
+		return nil, ErrNoEndpoint
 	}
 
-	return endpoints[0]
+	return endpoints[0], nil
 }
@@ -11,13 +11,19 @@ type roundRobinLoadBalancer struct {
 	mtx          sync.Mutex
 }
 
-func (lb *roundRobinLoadBalancer) Choose(endpoints []*endpointv3.LbEndpoint) *endpointv3.LbEndpoint {
+func (lb *roundRobinLoadBalancer) Choose(endpoints []*endpointv3.LbEndpoint) (*endpointv3.LbEndpoint, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+
 	lb.mtx.Lock()
 	defer lb.mtx.Unlock()
 
-	endpoint := endpoints[lb.currentIndex]
+	// the list may be shorter than on the previous call
+	index := lb.currentIndex % len(endpoints)
+	endpoint := endpoints[index]
 
-	lb.currentIndex = (lb.currentIndex + 1) % len(endpoints)
+	lb.currentIndex = (index + 1) % len(endpoints)
 
-	return endpoint
+	return endpoint, nil
 }
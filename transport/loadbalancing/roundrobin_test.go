@@ -1,6 +1,8 @@
 package loadbalancing_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
@@ -47,8 +49,102 @@ func TestRoundRobinLoadBalancer(t *testing.T) {
 
 	chosenHosts := []string{}
 	for i := 0; i <= 4; i++ {
-		chosenHosts = append(chosenHosts, loadbalancing.ChooseEndpoint(cluster).Hostname)
+		endpoint, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+		assert.NoError(t, err)
+		chosenHosts = append(chosenHosts, endpoint.Hostname)
 	}
 
 	assert.Equal(t, expectedChoice, chosenHosts)
 }
+
+func TestChooseEndpoint_ConcurrentRequests_ShouldNotRace(t *testing.T) {
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 1000; i++ {
+				cluster := &clusterv3.Cluster{
+					Name:     fmt.Sprintf("concurrent_cluster_%d", i),
+					LbPolicy: clusterv3.Cluster_ROUND_ROBIN,
+					LoadAssignment: &endpointv3.ClusterLoadAssignment{
+						Endpoints: []*endpointv3.LocalityLbEndpoints{{
+							LbEndpoints: []*endpointv3.LbEndpoint{{
+								HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{Hostname: "Host 1"}},
+							}, {
+								HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{Hostname: "Host 2"}},
+							}},
+						}},
+					},
+				}
+				endpoint, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+				assert.NoError(t, err)
+				assert.NotNil(t, endpoint)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestRoundRobinLoadBalancer_ShrunkEndpoints_ShouldStartOver(t *testing.T) {
+	cluster := &clusterv3.Cluster{
+		Name:           "shrinking",
+		LbPolicy:       clusterv3.Cluster_ROUND_ROBIN,
+		LoadAssignment: loadAssignment("Host 1", "Host 2", "Host 3", "Host 4"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+		assert.NoError(t, err)
+	}
+
+	cluster.LoadAssignment = loadAssignment("Host 1", "Host 2")
+	endpoint, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Host 1", endpoint.Hostname)
+}
+
+func TestRoundRobinLoadBalancer_ShrunkEndpointList_ShouldNotPanic(t *testing.T) {
+	cluster := &clusterv3.Cluster{
+		Name:           "shrinking_list",
+		LbPolicy:       clusterv3.Cluster_ROUND_ROBIN,
+		LoadAssignment: loadAssignment("Host 1", "Host 2", "Host 3", "Host 4"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+		assert.NoError(t, err)
+	}
+
+	locality := cluster.LoadAssignment.Endpoints[0]
+	locality.LbEndpoints = locality.LbEndpoints[:2]
+	endpoint, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Host 2", endpoint.Hostname)
+}
+
+func TestChooseEndpoint_NoEndpoint_ShouldFail(t *testing.T) {
+	cluster := &clusterv3.Cluster{
+		Name:           "empty",
+		LbPolicy:       clusterv3.Cluster_ROUND_ROBIN,
+		LoadAssignment: loadAssignment(),
+	}
+
+	endpoint, err := loadbalancing.ChooseEndpoint(cluster, cluster.LoadAssignment)
+
+	assert.ErrorIs(t, err, loadbalancing.ErrNoEndpoint)
+	assert.Nil(t, endpoint)
+}
+
+func loadAssignment(hostnames ...string) *endpointv3.ClusterLoadAssignment {
+	locality := &endpointv3.LocalityLbEndpoints{}
+	for _, hostname := range hostnames {
+		locality.LbEndpoints = append(locality.LbEndpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{Hostname: hostname}},
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{Endpoints: []*endpointv3.LocalityLbEndpoints{locality}}
+}
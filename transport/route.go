@@ -1,19 +1,46 @@
 package transport
 
 import (
-	"fmt"
 	"net/http"
 
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
 )
 
-func (w *Wrapper) getFirstMatchedRoute(req *http.Request) *routev3.Route {
-	routes, err := w.cache.GetRouteConfig("")
-	if err != nil {
-		panic(fmt.Errorf("fail to get routes: %w", err))
+// getFirstMatchedRoute returns the first route of snapshot matching req, and
+// the generation it was found in, looking only at the route configurations
+// selected for req by selectRouteConfigs. When no virtual host matches and route
+// configurations enable VHDS, the virtual host of the request host is fetched
+// on demand, waiting for the management server within the limits of the
+// request context, and the route is looked up again in the generation holding
+// it.
+func getFirstMatchedRoute(req *http.Request, cache xdscache.XDSCache, snapshot *xdscache.Snapshot) (*routev3.Route, *xdscache.Snapshot, error) {
+	routeConfigs := selectRouteConfigs(req, snapshot)
+	if route := matchRoute(req, snapshot, routeConfigs); route != nil {
+		return route, snapshot, nil
+	}
+
+	fetched := false
+	for _, rc := range routeConfigs {
+		if rc.GetVhds() == nil {
+			continue
+		}
+		if err := cache.WatchVirtualHost(req.Context(), rc.GetName(), req.URL.Host); err != nil {
+			return nil, snapshot, err
+		}
+		fetched = true
+	}
+	if !fetched {
+		return nil, snapshot, nil
 	}
-	for _, rc := range routes {
-		for _, vh := range rc.VirtualHosts {
+	snapshot = cache.Snapshot()
+	return matchRoute(req, snapshot, selectRouteConfigs(req, snapshot)), snapshot, nil
+}
+
+func matchRoute(req *http.Request, snapshot *xdscache.Snapshot, routeConfigs []*routev3.RouteConfiguration) *routev3.Route {
+	for _, rc := range routeConfigs {
+		for _, vh := range snapshot.GetVirtualHosts(rc) {
 			if !doesMatchVirtualHost(req, vh) {
 				continue
 			}
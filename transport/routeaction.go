@@ -1,27 +1,51 @@
 package transport
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
-	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
 	"github.com/k3rn3l-p4n1c/gohttpxds/transport/loadbalancing"
 )
 
-func (w *Wrapper) doRouteAction(req *http.Request, ra *routev3.RouteAction) *http.Request {
-	cluster, err := w.getCluster(ra)
+var errNoHealthyUpstream = errors.New("no healthy upstream")
+
+func doRouteAction(req *http.Request, ra *routev3.RouteAction, snapshot *xdscache.Snapshot) (*http.Request, error) {
+	cluster, err := getCluster(ra, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("fail to find cluster: %w", err)
+	}
+
+	loadAssignment, err := getLoadAssignment(cluster, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("fail to find endpoints: %w", err)
+	}
+	endpoint, err := loadbalancing.ChooseEndpoint(cluster, loadAssignment)
 	if err != nil {
-		panic(fmt.Errorf("fail to find cluster: %w", err))
+		return nil, fmt.Errorf("cluster %q: %w: %v", cluster.GetName(), errNoHealthyUpstream, err)
 	}
 
-	add := loadbalancing.ChooseEndpoint(cluster[0]).Address.Address.(*corev3.Address_SocketAddress).SocketAddress
-	host := add.Address
-	port := add.PortSpecifier.(*corev3.SocketAddress_PortValue).PortValue
-	req.URL.Host = fmt.Sprintf("%s:%d", host, port)
+	host, err := endpointHost(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %q: %w", cluster.GetName(), err)
+	}
+	req.URL.Host = host
 	req.URL.Scheme = "http"
-	req.Host = fmt.Sprintf("%s:%d", host, port)
+	req.Host = host
 
-	return req
+	return req, nil
+}
+
+// endpointHost returns the host:port requests are sent to for endpoint. Only
+// socket addresses with a port value are supported.
+func endpointHost(endpoint *endpointv3.Endpoint) (string, error) {
+	address := endpoint.GetAddress().GetSocketAddress()
+	if address.GetAddress() == "" || address.GetPortValue() == 0 {
+		return "", fmt.Errorf("endpoint has no socket address with a port value")
+	}
+	return fmt.Sprintf("%s:%d", address.GetAddress(), address.GetPortValue()), nil
 }
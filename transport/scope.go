@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"net/http"
+	"strings"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
+)
+
+// selectRouteConfigs returns the route configurations req is matched against.
+// When listeners route by scope, these are only the ones of the scopes its
+// scope key selects in each scoped routes: requests whose scope key cannot be
+// built, or selects no scope, match none and are answered with a 404, as
+// Envoy does. Otherwise, every route configuration is.
+func selectRouteConfigs(req *http.Request, snapshot *xdscache.Snapshot) []*routev3.RouteConfiguration {
+	scopedRoutes := snapshot.GetScopedRoutes()
+	if len(scopedRoutes) == 0 {
+		return snapshot.GetRouteConfigs()
+	}
+
+	var selected []*routev3.RouteConfiguration
+	for _, sr := range scopedRoutes {
+		key, ok := scopeKey(req, sr.KeyBuilder)
+		if !ok {
+			continue
+		}
+		for _, scope := range sr.Scopes {
+			if !doesMatchScope(scope, key) {
+				continue
+			}
+			if rc, err := snapshot.GetRouteConfig(scope.GetRouteConfigurationName()); err == nil {
+				selected = append(selected, rc)
+			}
+			break
+		}
+	}
+	return selected
+}
+
+// scopeKey builds the scope key of req, one fragment per fragment builder. It
+// reports false if a fragment cannot be extracted from the request headers.
+func scopeKey(req *http.Request, builder *hcmv3.ScopedRoutes_ScopeKeyBuilder) ([]string, bool) {
+	key := make([]string, 0, len(builder.GetFragments()))
+	for _, fragment := range builder.GetFragments() {
+		value, ok := extractHeaderValue(req.Header, fragment.GetHeaderValueExtractor())
+		if !ok {
+			return nil, false
+		}
+		key = append(key, value)
+	}
+	return key, true
+}
+
+// extractHeaderValue returns the fragment extractor picks from the first value
+// of its header: the element at its index, or the value of its key-value
+// element, the elements being separated by its element separator.
+func extractHeaderValue(header http.Header, extractor *hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor) (string, bool) {
+	values := header.Values(extractor.GetName())
+	if len(values) == 0 {
+		return "", false
+	}
+	elements := []string{values[0]}
+	if separator := extractor.GetElementSeparator(); separator != "" {
+		elements = strings.Split(values[0], separator)
+	}
+
+	if kv := extractor.GetElement(); kv != nil {
+		for _, element := range elements {
+			if k, v, ok := strings.Cut(element, kv.GetSeparator()); ok && k == kv.GetKey() {
+				return v, true
+			}
+		}
+		return "", false
+	}
+	index := int(extractor.GetIndex())
+	if index >= len(elements) {
+		return "", false
+	}
+	return elements[index], true
+}
+
+func doesMatchScope(scope *routev3.ScopedRouteConfiguration, key []string) bool {
+	fragments := scope.GetKey().GetFragments()
+	if len(fragments) != len(key) {
+		return false
+	}
+	for i, fragment := range fragments {
+		if fragment.GetStringKey() != key[i] {
+			return false
+		}
+	}
+	return true
+}
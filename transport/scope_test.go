@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+	"testing"
+
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopeKey_IndexAndElement_ShouldExtractFragments(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://sub.domain.com/", nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	req.Header.Set("x-tenant", "acme")
+	req.Header.Set("x-route", "region=eu;env=prod")
+
+	builder := &hcmv3.ScopedRoutes_ScopeKeyBuilder{
+		Fragments: []*hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder{{
+			Type: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_{
+				HeaderValueExtractor: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor{
+					Name: "x-tenant",
+				},
+			},
+		}, {
+			Type: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_{
+				HeaderValueExtractor: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor{
+					Name:             "x-route",
+					ElementSeparator: ";",
+					ExtractType: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_Element{
+						Element: &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_KvElement{
+							Separator: "=",
+							Key:       "env",
+						},
+					},
+				},
+			},
+		}},
+	}
+
+	key, ok := scopeKey(req, builder)
+	assert.True(t, ok, "scope key should be built")
+	assert.Equal(t, []string{"acme", "prod"}, key)
+
+	req.Header.Del("x-tenant")
+	_, ok = scopeKey(req, builder)
+	assert.False(t, ok, "scope key should not be built without its header")
+}
+
+func TestExtractHeaderValue_IndexOutOfRange_ShouldFail(t *testing.T) {
+	header := http.Header{}
+	header.Set("x-tenant", "acme,eu")
+
+	extractor := &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor{
+		Name:             "x-tenant",
+		ElementSeparator: ",",
+		ExtractType:      &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_Index{Index: 1},
+	}
+	value, ok := extractHeaderValue(header, extractor)
+	assert.True(t, ok)
+	assert.Equal(t, "eu", value)
+
+	extractor.ExtractType = &hcmv3.ScopedRoutes_ScopeKeyBuilder_FragmentBuilder_HeaderValueExtractor_Index{Index: 2}
+	_, ok = extractHeaderValue(header, extractor)
+	assert.False(t, ok, "missing element should not be extracted")
+}
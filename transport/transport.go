@@ -2,24 +2,45 @@ package transport
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/k3rn3l-p4n1c/gohttpxds/internal/xdscache"
 
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	statusv3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 )
 
-func New(transport http.RoundTripper, cache xdscache.XDSCache) http.RoundTripper {
-	return &Wrapper{
+// Option configures the Wrapper built by New.
+type Option func(*Wrapper)
+
+// WithWaitForReady makes requests to xds:// URLs wait for the cache to be
+// ready, within the limits of their context, instead of being routed with an
+// incomplete config.
+func WithWaitForReady() Option {
+	return func(w *Wrapper) {
+		w.waitForReady = true
+	}
+}
+
+func New(transport http.RoundTripper, cache xdscache.XDSCache, opts ...Option) http.RoundTripper {
+	w := &Wrapper{
 		transport: transport,
 		cache:     cache,
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
 }
 
 type Wrapper struct {
-	transport http.RoundTripper
-	cache     xdscache.XDSCache
+	transport    http.RoundTripper
+	cache        xdscache.XDSCache
+	waitForReady bool
 }
 
 func (w *Wrapper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -27,41 +48,107 @@ func (w *Wrapper) RoundTrip(req *http.Request) (*http.Response, error) {
 		return w.transport.RoundTrip(req)
 	}
 
-	routev3 := w.getFirstMatchedRoute(req)
+	if w.waitForReady {
+		if err := w.cache.WaitForReady(req.Context()); err != nil {
+			return nil, fmt.Errorf("xds config not ready: %w", err)
+		}
+	}
+
+	// Every lookup of the request is made on the same generation of the
+	// cache, even if an update is published meanwhile.
+	snapshot := w.cache.Snapshot()
+	routev3, snapshot, err := getFirstMatchedRoute(req, w.cache, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("xds virtual host discovery: %w", err)
+	}
 	if routev3 == nil {
-		body := "No routev3 found"
-		return &http.Response{
-			Status:        "404 Not Found",
-			StatusCode:    404,
-			Proto:         "HTTP/1.1",
-			ProtoMajor:    1,
-			ProtoMinor:    1,
-			Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
-			ContentLength: int64(len(body)),
-			Request:       req,
-			Header:        make(http.Header, 0),
-		}, nil
+		return newResponse(req, http.StatusNotFound, "No routev3 found"), nil
+	}
+	upstreamReq, err := doAction(req, routev3, snapshot)
+	if err != nil {
+		return newResponse(req, http.StatusServiceUnavailable, err.Error()), nil
 	}
-	req = w.doAction(req, routev3)
+	req = upstreamReq
 
 	logRequest(req)
 
 	return roundTripWithRetry(req, w.transport.RoundTrip, routev3.GetRoute().GetRetryPolicy())
 }
 
-func (w *Wrapper) doAction(req *http.Request, r *routev3.Route) *http.Request {
+// WaitForReady blocks until the cache holds a complete config, or ctx is
+// done.
+func (w *Wrapper) WaitForReady(ctx context.Context) error {
+	return w.cache.WaitForReady(ctx)
+}
+
+// Subscribe calls fn for every change of the config routing the requests, see
+// xdscache.XDSCache.
+func (w *Wrapper) Subscribe(fn func(xdscache.Event)) (cancel func()) {
+	return w.cache.Subscribe(fn)
+}
+
+// ConfigDump returns the state of the xDS resources routing the requests, see
+// xdscache.XDSCache.
+func (w *Wrapper) ConfigDump() *adminv3.ConfigDump {
+	return w.cache.ConfigDump()
+}
+
+// ClientConfig returns the state of the watched xDS resources, see
+// xdscache.XDSCache.
+func (w *Wrapper) ClientConfig() *statusv3.ClientConfig {
+	return w.cache.ClientConfig()
+}
+
+// Close stops the xDS watches feeding the wrapper and closes the idle
+// connections of the underlying transport. Requests to xds:// URLs keep being
+// routed with the last received config.
+func (w *Wrapper) Close() {
+	w.cache.Close()
+	w.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes the idle connections of the underlying
+// transport, if it supports it.
+func (w *Wrapper) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if t, ok := w.transport.(closeIdler); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// newResponse builds a response generated locally instead of by an upstream.
+func newResponse(req *http.Request, statusCode int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+		Header:        make(http.Header, 0),
+	}
+}
+
+// doAction returns the request to send upstream for the matched route r. Only
+// routes forwarding to a cluster are supported, the other actions fail the
+// request rather than send it somewhere unintended.
+func doAction(req *http.Request, r *routev3.Route, snapshot *xdscache.Snapshot) (*http.Request, error) {
 	switch action := r.Action.(type) {
 	case *routev3.Route_Route:
-		return w.doRouteAction(req, action.Route)
+		return doRouteAction(req, action.Route, snapshot)
 	case *routev3.Route_Redirect:
-		panic("not implemented")
+		return nil, fmt.Errorf("route %q: redirect is not supported", r.GetName())
 	case *routev3.Route_DirectResponse:
-		panic("not implemented")
+		return nil, fmt.Errorf("route %q: direct_response is not supported", r.GetName())
 	case *routev3.Route_FilterAction:
-		panic("not implemented")
+		return nil, fmt.Errorf("route %q: filter_action is not supported", r.GetName())
 	case *routev3.Route_NonForwardingAction:
-		panic("not implemented")
+		return nil, fmt.Errorf("route %q: non_forwarding_action is not supported", r.GetName())
 	default:
-		panic("unknown routev3 action type")
+		return nil, fmt.Errorf("route %q has no action", r.GetName())
 	}
 }
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDoAction_Redirect_ShouldFail(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "xds://sub.domain.com/", nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	route := &routev3.Route{
+		Name:   "route_0",
+		Action: &routev3.Route_Redirect{Redirect: &routev3.RedirectAction{HostRedirect: "other.domain.com"}},
+	}
+
+	_, err = doAction(req, route, nil)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "redirect is not supported")
+}
+
+func TestChooseWeightedCluster_ShouldFollowWeights(t *testing.T) {
+	wc := &routev3.WeightedCluster{Clusters: []*routev3.WeightedCluster_ClusterWeight{
+		{Name: "cluster_0", Weight: wrapperspb.UInt32(1)},
+		{Name: "cluster_1", Weight: wrapperspb.UInt32(0)},
+		{Name: "cluster_2", Weight: wrapperspb.UInt32(3)},
+	}}
+
+	chosen := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		name, err := chooseWeightedCluster(wc)
+		assert.NoError(t, err)
+		chosen[name]++
+	}
+
+	assert.Zero(t, chosen["cluster_1"])
+	assert.InDelta(t, 1000, chosen["cluster_0"], 200)
+	assert.InDelta(t, 3000, chosen["cluster_2"], 200)
+}
+
+func TestChooseWeightedCluster_NoWeight_ShouldFail(t *testing.T) {
+	wc := &routev3.WeightedCluster{Clusters: []*routev3.WeightedCluster_ClusterWeight{{Name: "cluster_0"}}}
+
+	_, err := chooseWeightedCluster(wc)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no cluster with weight")
+}
+
+func TestEndpointHost_PipeAddress_ShouldFail(t *testing.T) {
+	endpoint := &endpointv3.Endpoint{
+		Address: &corev3.Address{Address: &corev3.Address_Pipe{Pipe: &corev3.Pipe{Path: "/tmp/upstream.sock"}}},
+	}
+
+	_, err := endpointHost(endpoint)
+
+	assert.Error(t, err)
+}
+
+func TestEndpointHost_SocketAddress_ShouldReturnHostAndPort(t *testing.T) {
+	endpoint := &endpointv3.Endpoint{
+		Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+			Address:       "10.0.0.1",
+			PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 8080},
+		}}},
+	}
+
+	host, err := endpointHost(endpoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:8080", host)
+}